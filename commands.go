@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	lt "diikstra.fr/letterboxd-jellyfin-go/letterboxd"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
+	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+)
+
+// Number of runs printed by the status command.
+const statusRuns = 5
+
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// Commands changing the state, the caches or the servers hold the app lock
+	// while they run.
+	locked bool
+	// Standalone commands run without the configuration and the state, so
+	// that they work even when those are broken.
+	standalone bool
+	run        func(a *app, args []string) error
+}
+
+var commands = []command{
+	{name: "sync", usage: "sync [--user X]", summary: "sync the watchlists and lists of every user, or of user X", locked: true, run: syncCommand(false)},
+	{name: "full-sync", usage: "full-sync [--user X]", summary: "same as sync, reconciling the whole watchlists", locked: true, run: syncCommand(true)},
+	{name: "serve", usage: "serve", summary: "keep running, sync the users on their schedule and receive the Jellyfin webhooks", locked: true, run: serveCommand},
+	{name: "import-export", usage: "import-export <user> <zip>", summary: "sync a user from a Letterboxd data export", locked: true, run: importExportCommand},
+	{name: "status", usage: "status", summary: "print the state of the users, the lock and the last runs", run: statusCommand},
+	{name: "users", usage: "users list | add <user> <jellyfin user> [collection id] | remove <user>", summary: "manage the users added outside of the configuration file", locked: true, run: usersCommand},
+	{name: "collection", usage: "collection show <user>", summary: "print the content of the collection and playlist of a user", run: collectionCommand},
+	{name: "radarr", usage: "radarr check [--user X] <tmdb id>", summary: "print the Radarr state and routing of a movie", run: radarrCommand},
+	{name: "cache", usage: "cache clear", summary: "remove the Letterboxd slug cache", locked: true, standalone: true, run: cacheCommand},
+	{name: "unlock", usage: "unlock", summary: "remove the lock left by another run", standalone: true, run: unlockCommand},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// parseFlags parses the flags of a command, which must leave nArgs
+// positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string, nArgs int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+	if flags.NArg() != nArgs {
+		return fmt.Errorf("%w: expected %d arguments, got %d", ErrUsage, nArgs, flags.NArg())
+	}
+	return nil
+}
+
+func syncCommand(forceFull bool) func(a *app, args []string) error {
+	return func(a *app, args []string) error {
+		name := "sync"
+		if forceFull {
+			name = "full-sync"
+		}
+		flags := flag.NewFlagSet(name, flag.ContinueOnError)
+		username := flags.String("user", "", "Letterboxd username of the only user to sync")
+		if err := parseFlags(flags, args, 0); err != nil {
+			return err
+		}
+
+		var users []*config.UserData
+		if *username != "" {
+			user, err := findUser(a.conf, *username)
+			if err != nil {
+				return err
+			}
+			users = append(users, user)
+		} else {
+			for index := range a.conf.Users {
+				users = append(users, &a.conf.Users[index])
+			}
+		}
+
+		s, err := a.loadSyncer()
+		if err != nil {
+			return err
+		}
+		return a.finishRun(name, syncUsers(a.ctx, s, users, forceFull, a.runPlan))
+	}
+}
+
+func serveCommand(a *app, args []string) error {
+	if err := parseFlags(flag.NewFlagSet("serve", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	if *dryRun {
+		return errors.New("serve does not support --dry-run")
+	}
+
+	s, err := a.loadSyncer()
+	if err != nil {
+		return err
+	}
+	srv, err := newServer(s, a.store, a.slugCache)
+	if err != nil {
+		return err
+	}
+	return srv.serve(a.ctx)
+}
+
+func importExportCommand(a *app, args []string) error {
+	flags := flag.NewFlagSet("import-export", flag.ContinueOnError)
+	if err := parseFlags(flags, args, 2); err != nil {
+		return err
+	}
+	user, err := findUser(a.conf, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	s, err := a.loadSyncer()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = s.importExport(a.ctx, user, flags.Arg(1))
+	return a.finishRun("import-export", []userResult{{Username: user.Username, Err: err, Duration: time.Since(start)}})
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func statusCommand(a *app, args []string) error {
+	if err := parseFlags(flag.NewFlagSet("status", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fullSyncInterval := time.Duration(a.conf.FullSyncIntervalHours) * time.Hour
+	fmt.Fprintf(tw, "USERS (%d)\n", len(a.conf.Users))
+	fmt.Fprintln(tw, "USER\tLATEST MOVIE\tLAST FULL SYNC\tFULL SYNC DUE\tSENT\tWATCHED\tCOLLECTION ITEMS")
+	for _, user := range a.conf.Users {
+		userState := a.store.User(user.Username)
+		items := "-"
+		if snapshot, ok := userState.Collections[a.store.WatchlistCollection(user)]; ok {
+			items = fmt.Sprintf("%d (%s)", len(snapshot.TmdbKeys), formatTime(snapshot.TakenAt))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%d\t%d\t%s\n", user.Username, userState.LatestWatchlistMovie, formatTime(userState.LastFullSync), userState.IsFullSyncDue(fullSyncInterval), len(userState.Sent), len(userState.Watched), items)
+	}
+
+	fmt.Fprintln(tw, "\nLOCK")
+	holder, err := config.ReadLock(a.lockPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Fprintln(tw, "not locked")
+	case err != nil:
+		fmt.Fprintf(tw, "unreadable: %v\n", err)
+	case holder.IsStale():
+		fmt.Fprintf(tw, "stale, held by %s\n", holder)
+	default:
+		fmt.Fprintf(tw, "held by %s\n", holder)
+	}
+
+	runs := a.store.Runs()
+	fmt.Fprintf(tw, "\nLAST RUNS (%d recorded)\n", len(runs))
+	fmt.Fprintln(tw, "STARTED\tCOMMAND\tDURATION\tUSERS\tFAILED")
+	for index := len(runs) - 1; index >= max(len(runs)-statusRuns, 0); index-- {
+		run := runs[index]
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", formatTime(run.StartedAt), run.Command, run.Duration.Round(time.Second), run.Users, run.Failed)
+	}
+
+	return tw.Flush()
+}
+
+func usersCommand(a *app, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: missing users subcommand", ErrUsage)
+	}
+	flags := flag.NewFlagSet("users "+args[0], flag.ContinueOnError)
+
+	switch args[0] {
+	case "list":
+		if err := parseFlags(flags, args[1:], 0); err != nil {
+			return err
+		}
+		added := a.store.AddedUsers()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tJELLYFIN USER\tCOLLECTION\tLISTS\tSOURCE")
+		for _, user := range a.conf.Users {
+			source := "config"
+			if slices.ContainsFunc(added, func(u config.UserData) bool { return u.Username == user.Username }) {
+				source = "added"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", user.Username, user.JellyfinUserName, a.store.WatchlistCollection(user), len(user.Lists), source)
+		}
+		return tw.Flush()
+
+	case "add":
+		// The collection id is optional, see config.UserData.CollectionId.
+		nArgs := 2
+		if len(args) > 3 {
+			nArgs = 3
+		}
+		if err := parseFlags(flags, args[1:], nArgs); err != nil {
+			return err
+		}
+		user := config.UserData{Username: flags.Arg(0), JellyfinUserName: flags.Arg(1), CollectionId: flags.Arg(2)}
+		if _, err := findUser(a.conf, user.Username); err == nil {
+			return fmt.Errorf("user %q already exists", user.Username)
+		}
+		// Catches a misspelled Jellyfin user before the first sync.
+		if _, err := jf.NewClient(a.fetcher, a.conf).GetUserId(a.ctx, user.JellyfinUserName); err != nil {
+			return err
+		}
+		if *dryRun {
+			a.planUserChange(user.Username, "", "added as Jellyfin user "+user.JellyfinUserName)
+			return nil
+		}
+		a.store.AddUser(user)
+		if err := a.store.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Added %s\n", user.Username)
+		return nil
+
+	case "remove":
+		if err := parseFlags(flags, args[1:], 1); err != nil {
+			return err
+		}
+		if *dryRun && slices.ContainsFunc(a.store.AddedUsers(), func(u config.UserData) bool { return strings.EqualFold(u.Username, flags.Arg(0)) }) {
+			a.planUserChange(flags.Arg(0), "added", "removed")
+			return nil
+		}
+		if !a.store.RemoveUser(flags.Arg(0)) {
+			if _, err := findUser(a.conf, flags.Arg(0)); err == nil {
+				return fmt.Errorf("user %q is defined in %s, remove it there", flags.Arg(0), config.ConfigPath())
+			}
+			return fmt.Errorf("user %q does not exist", flags.Arg(0))
+		}
+		if err := a.store.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", flags.Arg(0))
+		return nil
+	}
+	return fmt.Errorf("%w: unknown users subcommand %q", ErrUsage, args[0])
+}
+
+// planUserChange prints the change a dry run of the users command would make
+// to the added users.
+func (a *app) planUserChange(username string, old string, new string) {
+	a.runPlan.AddConfigChange(plan.ConfigChange{User: username, Field: "User", Old: old, New: new})
+	writePlan(a.runPlan)
+}
+
+func collectionCommand(a *app, args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("%w: expected collection show", ErrUsage)
+	}
+	flags := flag.NewFlagSet("collection show", flag.ContinueOnError)
+	if err := parseFlags(flags, args[1:], 1); err != nil {
+		return err
+	}
+	user, err := findUser(a.conf, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	jellyfin := jf.NewClient(a.fetcher, a.conf)
+	userId, err := jellyfin.GetUserId(a.ctx, user.JellyfinUserName)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for index, target := range storedTargets(a.conf, a.store, *user) {
+		if index > 0 {
+			fmt.Fprintln(tw)
+		}
+		kind := "collection"
+		if target.Playlist {
+			kind = "playlist"
+		}
+		if target.Id == "" {
+			fmt.Fprintf(tw, "The %s of %s is created on its first sync\n", kind, user.Username)
+			continue
+		}
+		items, err := jellyfin.GetUserViews(a.ctx, userId, target)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tw, "%s %s OF %s (%d items)\n", strings.ToUpper(kind), target.Id, user.Username, len(items))
+		fmt.Fprintln(tw, "NAME\tTYPE\tTMDB\tPLAYED")
+		for _, item := range items {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", item.Name, item.Type, item.TmdbKey(), item.UserData.Played)
+		}
+	}
+	return tw.Flush()
+}
+
+func radarrCommand(a *app, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("%w: expected radarr check", ErrUsage)
+	}
+	flags := flag.NewFlagSet("radarr check", flag.ContinueOnError)
+	username := flags.String("user", "", "Letterboxd username of the requesting user, for the rules matching users")
+	if err := parseFlags(flags, args[1:], 1); err != nil {
+		return err
+	}
+	tmdbId := flags.Arg(0)
+
+	radarr := rd.NewClient(a.fetcher, a.conf)
+	if err := radarr.ResolveSettings(a.ctx); err != nil {
+		return err
+	}
+	movie, err := radarr.GetRadarrState(a.ctx, tmdbId)
+	if err != nil {
+		return err
+	}
+	routing := radarr.Route(movie, *username)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Title\t%s (%d)\n", movie.Title, movie.ProductionYear)
+	fmt.Fprintf(tw, "TMDB / IMDb\t%s / %s\n", movie.TmdbId, movie.ImdbId)
+	fmt.Fprintf(tw, "In Radarr\t%v\n", movie.InLibrary)
+	fmt.Fprintf(tw, "Monitored\t%v\n", movie.Monitored)
+	fmt.Fprintf(tw, "Downloaded\t%v\n", movie.HasFile)
+	fmt.Fprintf(tw, "Genres\t%s\n", strings.Join(movie.Genres, ", "))
+	fmt.Fprintf(tw, "Language\t%s\n", movie.OriginalLanguage)
+	fmt.Fprintf(tw, "Rule\t%s\n", routing.Rule)
+	fmt.Fprintf(tw, "Root folder\t%s\n", routing.RootFolderPath)
+	fmt.Fprintf(tw, "Quality profile\t%d\n", routing.QualityProfileId)
+	fmt.Fprintf(tw, "Tags\t%v\n", routing.TagIds)
+	return tw.Flush()
+}
+
+func cacheCommand(a *app, args []string) error {
+	if len(args) != 1 || args[0] != "clear" {
+		return fmt.Errorf("%w: expected cache clear", ErrUsage)
+	}
+
+	if *dryRun {
+		if _, err := os.Stat(a.slugCachePath); errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("The slug cache is already empty")
+			return nil
+		}
+		cache, err := lt.LoadSlugCache(a.slugCachePath, 0)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Would remove the slug cache of %d films\n", cache.Len())
+		return nil
+	}
+
+	err := os.Remove(a.slugCachePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("The slug cache is already empty")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Removed the slug cache")
+	return nil
+}
+
+func unlockCommand(a *app, args []string) error {
+	if err := parseFlags(flag.NewFlagSet("unlock", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		holder, err := config.ReadLock(a.lockPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("The app is not locked")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the lock: %w", err)
+		}
+		fmt.Printf("Would remove the lock held by %s\n", holder)
+		return nil
+	}
+
+	holder, err := config.ForceUnlock(a.lockPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("The app is not locked")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove the lock: %w", err)
+	}
+	fmt.Printf("Removed the lock held by %s\n", holder)
+	return nil
+}
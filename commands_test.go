@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
+	"diikstra.fr/letterboxd-jellyfin-go/state"
+)
+
+func TestCommands(t *testing.T) {
+	jellyfin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]jf.User{{Name: "jellyfin-user", Id: "user"}})
+	}))
+	defer jellyfin.Close()
+
+	errAny := errors.New("any error")
+	writeLock := func(t *testing.T, a *app) {
+		info := config.LockInfo{Pid: os.Getppid(), Hostname: "host", StartedAt: time.Now(), Heartbeat: time.Now()}
+		data, _ := json.Marshal(info)
+		if err := os.WriteFile(a.lockPath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeSlugCache := func(t *testing.T, a *app) {
+		if err := os.WriteFile(a.slugCachePath, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addUser := func(t *testing.T, a *app) {
+		a.store.AddUser(config.UserData{Username: "added", JellyfinUserName: "jellyfin-user"})
+		if err := a.store.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	planned := func(check func(t *testing.T, a *app)) func(t *testing.T, a *app) {
+		return func(t *testing.T, a *app) {
+			check(t, a)
+			if len(a.runPlan.ConfigChanges) != 1 {
+				t.Errorf("planned changes = %v, want the change of the users", a.runPlan.ConfigChanges)
+			}
+		}
+	}
+	notExist := func(path func(a *app) string) func(t *testing.T, a *app) {
+		return func(t *testing.T, a *app) {
+			if _, err := os.Stat(path(a)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s still exists: %v", path(a), err)
+			}
+		}
+	}
+	exist := func(path func(a *app) string) func(t *testing.T, a *app) {
+		return func(t *testing.T, a *app) {
+			if _, err := os.Stat(path(a)); err != nil {
+				t.Errorf("%s was removed: %v", path(a), err)
+			}
+		}
+	}
+	savedUsers := func(want ...config.UserData) func(t *testing.T, a *app) {
+		return func(t *testing.T, a *app) {
+			saved, err := state.Open(filepath.Join(filepath.Dir(a.lockPath), "state.json"), 0)
+			if err != nil {
+				t.Fatalf("state.Open() returned error: %v", err)
+			}
+			got := saved.AddedUsers()
+			if len(got) != len(want) {
+				t.Fatalf("saved users = %v, want %v", got, want)
+			}
+			for index := range want {
+				if got[index].Username != want[index].Username || got[index].JellyfinUserName != want[index].JellyfinUserName || got[index].CollectionId != want[index].CollectionId {
+					t.Errorf("saved users = %v, want %v", got, want)
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		dryRun  bool
+		setup   func(t *testing.T, a *app)
+		wantErr error
+		check   func(t *testing.T, a *app)
+	}{
+		{name: "Test sync with an argument", args: []string{"sync", "someone"}, wantErr: ErrUsage},
+		{name: "Test sync with an unknown flag", args: []string{"full-sync", "--users", "someone"}, wantErr: ErrUsage},
+		{name: "Test status with an argument", args: []string{"status", "someone"}, wantErr: ErrUsage},
+		{name: "Test import-export missing the file", args: []string{"import-export", "someone"}, wantErr: ErrUsage},
+		{name: "Test collection without show", args: []string{"collection", "someone"}, wantErr: ErrUsage},
+		{name: "Test radarr check without id", args: []string{"radarr", "check", "--user", "someone"}, wantErr: ErrUsage},
+		{name: "Test users without subcommand", args: []string{"users"}, wantErr: ErrUsage},
+		{name: "Test users unknown subcommand", args: []string{"users", "rename", "someone"}, wantErr: ErrUsage},
+		{name: "Test users add missing the Jellyfin user", args: []string{"users", "add", "new"}, wantErr: ErrUsage},
+		{name: "Test users add too many arguments", args: []string{"users", "add", "new", "jellyfin-user", "collection", "other"}, wantErr: ErrUsage},
+		{
+			name:  "Test users add",
+			args:  []string{"users", "add", "new", "jellyfin-user"},
+			check: savedUsers(config.UserData{Username: "new", JellyfinUserName: "jellyfin-user"}),
+		},
+		{
+			name:  "Test users add with collection",
+			args:  []string{"users", "add", "new", "jellyfin-user", "collection"},
+			check: savedUsers(config.UserData{Username: "new", JellyfinUserName: "jellyfin-user", CollectionId: "collection"}),
+		},
+		{name: "Test users add existing user", args: []string{"users", "add", "someone", "jellyfin-user"}, wantErr: errAny},
+		{name: "Test users add unknown Jellyfin user", args: []string{"users", "add", "new", "nobody"}, wantErr: jf.ErrUserNotFound},
+		{name: "Test users remove missing the user", args: []string{"users", "remove"}, wantErr: ErrUsage},
+		{
+			name:  "Test users remove",
+			args:  []string{"users", "remove", "added"},
+			setup: addUser,
+			check: savedUsers(),
+		},
+		{
+			name:   "Test users add dry run",
+			args:   []string{"users", "add", "new", "jellyfin-user"},
+			dryRun: true,
+			check:  planned(savedUsers()),
+		},
+		{
+			name:   "Test users remove dry run",
+			args:   []string{"users", "remove", "added"},
+			dryRun: true,
+			setup:  addUser,
+			check:  planned(savedUsers(config.UserData{Username: "added", JellyfinUserName: "jellyfin-user"})),
+		},
+		{name: "Test users remove configured user", args: []string{"users", "remove", "someone"}, wantErr: errAny},
+		{name: "Test users remove unknown user", args: []string{"users", "remove", "nobody"}, wantErr: errAny},
+		{
+			name:  "Test cache clear",
+			args:  []string{"cache", "clear"},
+			setup: writeSlugCache,
+			check: notExist(func(a *app) string { return a.slugCachePath }),
+		},
+		{
+			name:   "Test cache clear dry run",
+			args:   []string{"cache", "clear"},
+			dryRun: true,
+			setup:  writeSlugCache,
+			check:  exist(func(a *app) string { return a.slugCachePath }),
+		},
+		{name: "Test cache clear without cache", args: []string{"cache", "clear"}},
+		{name: "Test cache without subcommand", args: []string{"cache"}, wantErr: ErrUsage},
+		{
+			name:  "Test unlock",
+			args:  []string{"unlock"},
+			setup: writeLock,
+			check: notExist(func(a *app) string { return a.lockPath }),
+		},
+		{
+			name:   "Test unlock dry run",
+			args:   []string{"unlock"},
+			dryRun: true,
+			setup:  writeLock,
+			check:  exist(func(a *app) string { return a.lockPath }),
+		},
+		{name: "Test unlock without lock", args: []string{"unlock"}},
+		{name: "Test unlock with an argument", args: []string{"unlock", "now"}, setup: writeLock, wantErr: ErrUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := state.Open(filepath.Join(dir, "state.json"), 0)
+			if err != nil {
+				t.Fatalf("state.Open() returned error: %v", err)
+			}
+			a := &app{
+				ctx:   context.Background(),
+				start: time.Now(),
+				conf: &config.Configuration{
+					JellyfinUrl: jellyfin.URL,
+					Users:       []config.UserData{{Username: "someone", JellyfinUserName: "jellyfin-user"}},
+				},
+				store:         store,
+				fetcher:       f.Fetcher{Retry: &f.NoRetry},
+				lockPath:      filepath.Join(dir, "lock"),
+				slugCachePath: filepath.Join(dir, "slug_cache.json"),
+			}
+			if tt.dryRun {
+				*dryRun = true
+				t.Cleanup(func() { *dryRun = false })
+				a.runPlan = &plan.Plan{}
+			}
+			if tt.setup != nil {
+				tt.setup(t, a)
+			}
+
+			cmd, ok := findCommand(tt.args[0])
+			if !ok {
+				t.Fatalf("findCommand(%q) found no command", tt.args[0])
+			}
+			err = cmd.run(a, tt.args[1:])
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("%s returned error: %v", tt.args, err)
+			case tt.wantErr == errAny && err == nil:
+				t.Fatalf("%s returned no error", tt.args)
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Fatalf("%s error = %v, want %v", tt.args, err, tt.wantErr)
+			case tt.wantErr == errAny && errors.Is(err, ErrUsage):
+				t.Fatalf("%s error = %v, want an error other than %v", tt.args, err, ErrUsage)
+			}
+			if tt.check != nil {
+				tt.check(t, a)
+			}
+		})
+	}
+
+	if _, ok := findCommand("rename"); ok {
+		t.Errorf("findCommand() found the unknown command rename")
+	}
+}
@@ -1,12 +1,11 @@
 package config
 
 import (
-	"encoding/json"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
-	"time"
+	"slices"
 )
 
 var (
@@ -15,65 +14,218 @@ var (
 )
 
 const confFilePath = "config.json"
+const slugCacheFilePath = "slug_cache.json"
+const stateFilePath = "state.json"
+
+const DefaultCollectionName = "{user}'s Watchlist"
+
+// DefaultLetterboxdRequestsPerMinute keeps the proxy from being flagged when
+// LetterboxdRequestsPerMinute is not set.
+const DefaultLetterboxdRequestsPerMinute = 20
+
+// Values of Configuration.WatchlistOutput, empty means OutputCollection.
+const (
+	OutputCollection = "collection"
+	OutputPlaylist   = "playlist"
+	OutputBoth       = "both"
+)
 
 type UserData struct {
-	Username             string
-	LatestWatchlistMovie string
-	CollectionId         string
-	JellyfinUserName     string
-	LastFullSync         time.Time
+	Username string
+	// Jellyfin collection of the watchlist. When empty or deleted, a
+	// collection is created for the user, see CollectionName.
+	CollectionId     string `json:",omitempty"`
+	JellyfinUserName string
+	// Letterboxd lists the user subscribed to.
+	Lists []LetterboxdList `json:",omitempty"`
+}
+
+// LetterboxdList mirrors the public list https://letterboxd.com/<Owner>/list/<Slug>/
+// into its own Jellyfin collection. Movies of the list sent to Radarr get the
+// optional RadarrTag on top of the tags of their rule.
+type LetterboxdList struct {
+	Owner        string
+	Slug         string
+	CollectionId string
+	RadarrTag    string `json:",omitempty"`
+}
+
+// ListRadarrTags returns the Radarr tags used by the lists of every user.
+func (c Configuration) ListRadarrTags() []string {
+	var tags []string
+	for _, user := range c.Users {
+		for _, list := range user.Lists {
+			if list.RadarrTag != "" && !slices.Contains(tags, list.RadarrTag) {
+				tags = append(tags, list.RadarrTag)
+			}
+		}
+	}
+	return tags
+}
+
+// RadarrRuleMatch lists the conditions a movie must meet for a rule to apply.
+// Empty fields match every movie, list fields match if any value matches and
+// 0 leaves a bound of a range open.
+type RadarrRuleMatch struct {
+	Genres            []string `json:",omitempty"`
+	OriginalLanguages []string `json:",omitempty"`
+	YearMin           int      `json:",omitempty"`
+	YearMax           int      `json:",omitempty"`
+	RuntimeMin        int      `json:",omitempty"`
+	RuntimeMax        int      `json:",omitempty"`
+	Certifications    []string `json:",omitempty"`
+	// Letterboxd usernames of the requesting users.
+	Users []string `json:",omitempty"`
+}
+
+// RadarrRule routes the movies it matches. RootFolder is a key of
+// RadarrRootPaths or a path, QualityProfile and Tags are Radarr names. Empty
+// fields fall back to the default routing.
+type RadarrRule struct {
+	Name                string
+	Match               RadarrRuleMatch
+	RootFolder          string   `json:",omitempty"`
+	QualityProfile      string   `json:",omitempty"`
+	Tags                []string `json:",omitempty"`
+	MinimumAvailability string   `json:",omitempty"`
 }
 
 type Configuration struct {
-	Users           []UserData
-	ProxyUrl        string
-	ProxyUser       string
-	ProxyPass       string
-	CollectionIds   map[string]string
+	Users []UserData
+	// Base urls of the servers, the Radarr one includes the API prefix
+	// (http://localhost:7878/api/v3/). API keys left empty are read from the
+	// JELLYFIN_API_KEY and RADARR_API_KEY environment variables.
+	JellyfinUrl    string
+	JellyfinApiKey string `json:",omitempty"`
+	RadarrUrl      string
+	RadarrApiKey   string `json:",omitempty"`
+	// Name of the Radarr quality profile, RadarrQualityProfileId is only used
+	// when it is empty. Both are checked against Radarr at startup.
+	RadarrQualityProfile   string
+	RadarrQualityProfileId int
+	// Read from the PROXY_URL, PROXY_USER and PROXY_PASS environment
+	// variables only.
+	ProxyUrl        string `json:"-"`
+	ProxyUser       string `json:"-"`
+	ProxyPass       string `json:"-"`
 	RadarrRootPaths map[string]string
+	// Watchlist collections created for the users, {user} and {jellyfin_user}
+	// are replaced by the Letterboxd and Jellyfin user names in the name and
+	// the overview. The name defaults to DefaultCollectionName and Jellyfin
+	// downloads the poster from CollectionPosterUrl when set.
+	CollectionName      string `json:",omitempty"`
+	CollectionOverview  string `json:",omitempty"`
+	CollectionPosterUrl string `json:",omitempty"`
+	// Where the watchlists are synced, one of the Output constants. The
+	// collections are visible to every user of the library while the
+	// playlists are private to their Jellyfin user, they are named like the
+	// created collections.
+	WatchlistOutput string `json:",omitempty"`
+	// Evaluated in order before adding a movie to Radarr, the first matching
+	// rule wins.
+	RadarrRules []RadarrRule
+	// Series are sent to Sonarr only when SonarrUrl is set, using the series
+	// and anime_series keys of RadarrRootPaths. The API key falls back to the
+	// SONARR_API_KEY environment variable. SonarrMonitor selects the seasons
+	// monitored on add ("all" when empty).
+	SonarrUrl              string
+	SonarrApiKey           string `json:",omitempty"`
+	SonarrQualityProfile   string
+	SonarrQualityProfileId int
+	SonarrMonitor          string
+	// Maximum duration of a single HTTP request, 0 uses the fetcher default.
+	RequestTimeoutSeconds int
+	// Maximum duration of a whole run, 0 means no limit.
+	RunBudgetMinutes int
+	// Requests per minute sent to Letterboxd, shared by every worker. 0
+	// uses DefaultLetterboxdRequestsPerMinute.
+	LetterboxdRequestsPerMinute int
+	// Number of film pages resolved concurrently and of users synced
+	// concurrently, 0 processes them one by one.
+	ScrapeWorkers   int
+	ConcurrentUsers int
+	// Age after which a cached Letterboxd film is fetched again, 0 means
+	// cached films never expire.
+	SlugCacheTTLDays int
+	// Interval between two full watchlist reconciliations of a user, 0
+	// disables them.
+	FullSyncIntervalHours int
+	// Remove collection movies that are not in the watchlist anymore during
+	// full syncs, once they have been missing for the grace period.
+	RemoveUnlistedMovies     bool
+	UnlistedGracePeriodHours int
+	// Number of previous state files kept as state.json.1 (newest) to
+	// state.json.N.
+	StateBackups int
+	// Schedule of the serve command. Every user is synced every
+	// SyncIntervalMinutes, or on the SyncSchedule cron expression when set.
+	// FullSyncSchedule forces full syncs on its own cron expression on top of
+	// FullSyncIntervalHours. Each sync starts up to ScheduleJitterSeconds late
+	// so that the users are spread.
+	SyncIntervalMinutes   int
+	SyncSchedule          string `json:",omitempty"`
+	FullSyncSchedule      string `json:",omitempty"`
+	ScheduleJitterSeconds int
+	// serve listens for the notifications of the Jellyfin Webhook plugin on
+	// WebhookListenAddress (":8080"), empty disables it. The requests must
+	// carry WebhookSecret in their X-Webhook-Secret header, it falls back to
+	// the WEBHOOK_SECRET environment variable.
+	WebhookListenAddress string `json:",omitempty"`
+	WebhookSecret        string `json:",omitempty"`
 }
 
-func LoadConfiguration() Configuration {
-	file, err := os.Open(filepath.Join(basepath, confFilePath))
+// SyncsCollections reports whether the watchlists are synced to collections.
+func (c Configuration) SyncsCollections() bool {
+	return c.WatchlistOutput != OutputPlaylist
+}
+
+// SyncsPlaylists reports whether the watchlists are synced to playlists.
+func (c Configuration) SyncsPlaylists() bool {
+	return c.WatchlistOutput == OutputPlaylist || c.WatchlistOutput == OutputBoth
+}
+
+// LoadConfiguration reads the configuration file (see ConfigPath), the app
+// never writes it. Invalid configurations return an ErrInvalidConfig error
+// listing every problem.
+func LoadConfiguration() (Configuration, error) {
+	path := ConfigPath()
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Println("Fail to open config file")
-		log.Fatal(err)
+		return Configuration{}, fmt.Errorf("failed to open config file: %w", err)
 	}
-	defer file.Close()
-	decoder := json.NewDecoder(file)
-	configuration := Configuration{}
-	err = decoder.Decode(&configuration)
+	configuration, err := parseConfiguration(path, data, os.Environ())
 	if err != nil {
-		log.Println("Fail to decode config file")
-		log.Fatal(err)
+		return Configuration{}, err
 	}
 
 	configuration.ProxyUrl = os.Getenv("PROXY_URL")
 	configuration.ProxyUser = os.Getenv("PROXY_USER")
 	configuration.ProxyPass = os.Getenv("PROXY_PASS")
 
-	return configuration
-}
-
-func IsLocked() bool {
-	if _, err := os.Stat(filepath.Join(basepath, "app.lock")); err == nil {
-		return true
+	if configuration.JellyfinApiKey == "" {
+		configuration.JellyfinApiKey = os.Getenv("JELLYFIN_API_KEY")
+	}
+	if configuration.RadarrApiKey == "" {
+		configuration.RadarrApiKey = os.Getenv("RADARR_API_KEY")
+	}
+	if configuration.SonarrApiKey == "" {
+		configuration.SonarrApiKey = os.Getenv("SONARR_API_KEY")
+	}
+	if configuration.WebhookSecret == "" {
+		configuration.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
 	}
 
-	os.Create(filepath.Join(basepath, "app.lock"))
-	return false
+	if problems := configuration.validate(); len(problems) > 0 {
+		return Configuration{}, invalidConfig(path, problems)
+	}
+	return configuration, nil
 }
 
-func Unlock() {
-	os.Remove(filepath.Join(basepath, "app.lock"))
+// StatePath is the file of the state store, see the state package.
+func StatePath() string {
+	return filepath.Join(basepath, stateFilePath)
 }
 
-func PersistChanges(configuration Configuration) {
-	json, err := json.Marshal(configuration)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	os.WriteFile(filepath.Join(basepath, confFilePath), json, 0777)
+func SlugCachePath() string {
+	return filepath.Join(basepath, slugCacheFilePath)
 }
@@ -16,6 +16,9 @@ func TestLoadConfiguration(t *testing.T) {
 		t.Fatalf("Failed to change directory")
 	}
 
-	conf := LoadConfiguration()
+	conf, err := LoadConfiguration()
+	if err != nil {
+		t.Fatalf("LoadConfiguration() error = %v", err)
+	}
 	fmt.Println(conf)
 }
@@ -0,0 +1,23 @@
+//go:build unix
+
+package config
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// flockSupported reports whether the lock file is locked by the system, the
+// process holding it is then known to be alive.
+const flockSupported = true
+
+// tryFlock takes the exclusive lock of file without waiting, it reports false
+// when another open file holds it. The lock is released when file is closed.
+func tryFlock(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Configuration files looked up in the config directory, the first one found
+// is used.
+var confFileNames = []string{"config.yaml", "config.yml", "config.toml", confFilePath}
+
+// Environment variables named LJG_<FIELD> override the fields of the
+// configuration, see applyEnvOverrides.
+const envPrefix = "LJG_"
+
+// Keys of older versions, accepted but ignored. The ones of the users are
+// only read by state.Store.ImportLegacy.
+var legacyKeys = map[reflect.Type][]string{
+	configurationType: {"CollectionIds"},
+	userDataType:      {"LatestWatchlistMovie", "LastFullSync", "MissingSince"},
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	configurationType = reflect.TypeOf(Configuration{})
+	userDataType      = reflect.TypeOf(UserData{})
+	envReference      = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// ConfigPath returns the configuration file in use, config.json when none
+// exists.
+func ConfigPath() string {
+	for _, name := range confFileNames {
+		path := filepath.Join(basepath, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(basepath, confFilePath)
+}
+
+// parseConfiguration decodes the YAML, TOML or JSON configuration, chosen by
+// the extension of path. The ${NAME} references of its string values are
+// replaced by the environment variables and the LJG_* variables override its
+// fields. Every problem found is reported in the returned error.
+func parseConfiguration(path string, data []byte, environ []string) (Configuration, error) {
+	tree, err := decodeTree(path, data)
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	env := make(map[string]string)
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		env[name] = value
+	}
+
+	var problems []string
+	tree = interpolate(tree, "", env, &problems).(map[string]any)
+	applyEnvOverrides(tree, env, &problems)
+	checkTree(tree, reflect.TypeOf(Configuration{}), "", &problems)
+	if len(problems) > 0 {
+		return Configuration{}, invalidConfig(path, problems)
+	}
+
+	// The tree matches the Configuration fields, encoding/json does the rest.
+	normalized, err := json.Marshal(tree)
+	if err != nil {
+		return Configuration{}, err
+	}
+	configuration := Configuration{}
+	if err := json.Unmarshal(normalized, &configuration); err != nil {
+		return Configuration{}, invalidConfig(path, []string{err.Error()})
+	}
+	return configuration, nil
+}
+
+func invalidConfig(path string, problems []string) error {
+	return fmt.Errorf("%w %s:\n  - %s", ErrInvalidConfig, filepath.Base(path), strings.Join(problems, "\n  - "))
+}
+
+// decodeTree decodes the file into maps, lists and scalars.
+func decodeTree(path string, data []byte) (map[string]any, error) {
+	var tree map[string]any
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		_, err = toml.Decode(string(data), &tree)
+	default:
+		err = json.Unmarshal(data, &tree)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode %s: %w", ErrInvalidConfig, filepath.Base(path), err)
+	}
+	if tree == nil {
+		tree = make(map[string]any)
+	}
+	return normalize(tree).(map[string]any), nil
+}
+
+// normalize converts the maps and lists of the YAML and TOML decoders to
+// map[string]any and []any.
+func normalize(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case map[any]any:
+		converted := make(map[string]any, len(v))
+		for key, item := range v {
+			converted[fmt.Sprint(key)] = normalize(item)
+		}
+		return converted
+	case []map[string]any:
+		converted := make([]any, len(v))
+		for index, item := range v {
+			converted[index] = normalize(item)
+		}
+		return converted
+	case []any:
+		for index, item := range v {
+			v[index] = normalize(item)
+		}
+		return v
+	}
+	return value
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// interpolate replaces the ${NAME} references of the string values with the
+// environment variables, an unset variable is a problem.
+func interpolate(value any, path string, env map[string]string, problems *[]string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = interpolate(item, joinPath(path, key), env, problems)
+		}
+	case []any:
+		for index, item := range v {
+			v[index] = interpolate(item, fmt.Sprintf("%s[%d]", path, index), env, problems)
+		}
+	case string:
+		return envReference.ReplaceAllStringFunc(v, func(reference string) string {
+			name := envReference.FindStringSubmatch(reference)[1]
+			variable, ok := env[name]
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: environment variable %s is not set", path, name))
+			}
+			return variable
+		})
+	}
+	return value
+}
+
+// envName converts a field name to its environment form, RadarrQualityProfileId
+// becomes RADARR_QUALITY_PROFILE_ID and SlugCacheTTLDays SLUG_CACHE_TTL_DAYS.
+func envName(name string) string {
+	runes := []rune(name)
+	var builder strings.Builder
+	for index, r := range runes {
+		if index > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(runes[index-1]) || (index+1 < len(runes) && unicode.IsLower(runes[index+1]))) {
+			builder.WriteRune('_')
+		}
+		builder.WriteRune(unicode.ToUpper(r))
+	}
+	return builder.String()
+}
+
+// jsonName returns the key of the field in the configuration file, false for
+// the fields that cannot be set there.
+func jsonName(field reflect.StructField) (string, bool) {
+	tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if tag == "-" || !field.IsExported() {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return field.Name, true
+}
+
+// fieldByKey finds the struct field of the key, case-insensitively like
+// encoding/json does.
+func fieldByKey(t reflect.Type, key string, nameOf func(string) string) (reflect.StructField, string, bool) {
+	for index := range t.NumField() {
+		field := t.Field(index)
+		name, ok := jsonName(field)
+		if ok && strings.EqualFold(nameOf(name), key) {
+			return field, name, true
+		}
+	}
+	return reflect.StructField{}, "", false
+}
+
+// existingKey returns the key of the map equal to key ignoring case, or
+// fallback when there is none.
+func existingKey(m map[string]any, key string, fallback string) string {
+	for existing := range m {
+		if strings.EqualFold(existing, key) {
+			return existing
+		}
+	}
+	return fallback
+}
+
+// applyEnvOverrides sets the fields named by the LJG_* environment variables.
+// Nested fields are separated by a double underscore: LJG_RADARR_URL,
+// LJG_USERS__0__COLLECTION_ID or LJG_RADARR_ROOT_PATHS__MOVIES. Lists, maps and
+// structs can also be given whole as JSON, LJG_RADARR_RULES='[...]'.
+func applyEnvOverrides(tree map[string]any, env map[string]string, problems *[]string) {
+	var names []string
+	for name := range env {
+		if strings.HasPrefix(name, envPrefix) {
+			names = append(names, name)
+		}
+	}
+	// Whole values are applied before the fields they contain.
+	slices.Sort(names)
+
+	for _, name := range names {
+		segments := strings.Split(strings.TrimPrefix(name, envPrefix), "__")
+		if _, err := override(tree, reflect.TypeOf(Configuration{}), segments, env[name]); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+}
+
+// override sets the value at the path given by segments in container, a node
+// of type t, and returns the updated node.
+func override(container any, t reflect.Type, segments []string, value string) (any, error) {
+	if len(segments) == 0 {
+		return parseEnvValue(t, value)
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && t != timeType:
+		m, ok := container.(map[string]any)
+		if !ok {
+			m = make(map[string]any)
+		}
+		field, name, ok := fieldByKey(t, segments[0], envName)
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", segments[0])
+		}
+		key := existingKey(m, name, name)
+		item, err := override(m[key], field.Type, segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = item
+		return m, nil
+
+	case t.Kind() == reflect.Slice:
+		list, _ := container.([]any)
+		index, err := strconv.Atoi(segments[0])
+		if err != nil || index < 0 || index > len(list) {
+			return nil, fmt.Errorf("invalid index %s, the list has %d items", segments[0], len(list))
+		}
+		if index == len(list) {
+			list = append(list, nil)
+		}
+		item, err := override(list[index], t.Elem(), segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		list[index] = item
+		return list, nil
+
+	case t.Kind() == reflect.Map:
+		m, ok := container.(map[string]any)
+		if !ok {
+			m = make(map[string]any)
+		}
+		key := existingKey(m, segments[0], strings.ToLower(segments[0]))
+		item, err := override(m[key], t.Elem(), segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = item
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("%s is not a field", strings.Join(segments, "__"))
+}
+
+func parseEnvValue(t reflect.Type, value string) (any, error) {
+	switch {
+	case t == timeType, t.Kind() == reflect.String:
+		return value, nil
+	case t.Kind() == reflect.Int:
+		return strconv.Atoi(value)
+	case t.Kind() == reflect.Bool:
+		return strconv.ParseBool(value)
+	}
+
+	var parsed any
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid JSON value: %w", err)
+	}
+	return parsed, nil
+}
+
+// describe names the type of a decoded value in problems.
+func describe(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "a table"
+	case []any:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case time.Time:
+		return "a date"
+	}
+	return "a number"
+}
+
+// checkTree reports the unknown keys and the values that do not match the
+// type of their field.
+func checkTree(value any, t reflect.Type, path string, problems *[]string) {
+	if value == nil {
+		return
+	}
+	mismatch := func(expected string) {
+		*problems = append(*problems, fmt.Sprintf("%s: expected %s, got %s", path, expected, describe(value)))
+	}
+
+	switch {
+	case t == timeType:
+		switch v := value.(type) {
+		case time.Time:
+		case string:
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s: %q is not an RFC 3339 date", path, v))
+			}
+		default:
+			mismatch("a date")
+		}
+
+	case t.Kind() == reflect.Struct:
+		m, ok := value.(map[string]any)
+		if !ok {
+			mismatch("a table")
+			return
+		}
+		for key, item := range m {
+			field, _, ok := fieldByKey(t, key, func(name string) string { return name })
+			if !ok {
+				if slices.ContainsFunc(legacyKeys[t], func(legacy string) bool { return strings.EqualFold(legacy, key) }) {
+					continue
+				}
+				*problems = append(*problems, fmt.Sprintf("%s: unknown key", joinPath(path, key)))
+				continue
+			}
+			checkTree(item, field.Type, joinPath(path, key), problems)
+		}
+
+	case t.Kind() == reflect.Slice:
+		list, ok := value.([]any)
+		if !ok {
+			mismatch("a list")
+			return
+		}
+		for index, item := range list {
+			checkTree(item, t.Elem(), fmt.Sprintf("%s[%d]", path, index), problems)
+		}
+
+	case t.Kind() == reflect.Map:
+		m, ok := value.(map[string]any)
+		if !ok {
+			mismatch("a table")
+			return
+		}
+		for key, item := range m {
+			checkTree(item, t.Elem(), joinPath(path, key), problems)
+		}
+
+	case t.Kind() == reflect.String:
+		if _, ok := value.(string); !ok {
+			mismatch("a string")
+		}
+
+	case t.Kind() == reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			mismatch("a boolean")
+		}
+
+	case t.Kind() == reflect.Int:
+		switch v := value.(type) {
+		case int, int64, uint64:
+		case float64:
+			if v != float64(int(v)) {
+				mismatch("an integer")
+			}
+		default:
+			mismatch("an integer")
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testJSONConfig = `{
+	"JellyfinUrl": "https://jellyfin.example/",
+	"RadarrUrl": "${RADARR_HOST}/api/v3/",
+	"RadarrQualityProfileId": 11,
+	"RadarrRootPaths": {"movies": "/data/movies", "anime_movies": "/data/anime"},
+	"Users": [
+		{"Username": "user", "CollectionId": "abc", "JellyfinUserName": "admin", "LatestWatchlistMovie": "914"}
+	],
+	"CollectionIds": null,
+	"FullSyncIntervalHours": 168
+}`
+
+const testYAMLConfig = `
+JellyfinUrl: https://jellyfin.example/
+RadarrUrl: ${RADARR_HOST}/api/v3/
+RadarrQualityProfileId: 11
+RadarrRootPaths:
+  movies: /data/movies
+  anime_movies: /data/anime
+Users:
+  - Username: user
+    CollectionId: abc
+    JellyfinUserName: admin
+    latestWatchlistMovie: "914"
+collectionIds: []
+FullSyncIntervalHours: 168
+`
+
+const testTOMLConfig = `
+JellyfinUrl = "https://jellyfin.example/"
+RadarrUrl = "${RADARR_HOST}/api/v3/"
+RadarrQualityProfileId = 11
+FullSyncIntervalHours = 168
+
+[RadarrRootPaths]
+movies = "/data/movies"
+anime_movies = "/data/anime"
+
+[[Users]]
+Username = "user"
+CollectionId = "abc"
+JellyfinUserName = "admin"
+`
+
+func TestParseConfiguration(t *testing.T) {
+	want := Configuration{
+		JellyfinUrl:            "https://jellyfin.example/",
+		RadarrUrl:              "http://radarr:7878/api/v3/",
+		RadarrQualityProfileId: 11,
+		RadarrRootPaths:        map[string]string{"movies": "/data/movies", "anime_movies": "/data/anime"},
+		Users:                  []UserData{{Username: "user", CollectionId: "abc", JellyfinUserName: "admin"}},
+		FullSyncIntervalHours:  168,
+	}
+	environ := []string{"RADARR_HOST=http://radarr:7878"}
+
+	tests := []struct {
+		name string
+		path string
+		data string
+	}{
+		{name: "Test JSON", path: "config.json", data: testJSONConfig},
+		{name: "Test YAML", path: "config.yaml", data: testYAMLConfig},
+		{name: "Test TOML", path: "config.toml", data: testTOMLConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfiguration(tt.path, []byte(tt.data), environ)
+			if err != nil {
+				t.Fatalf("parseConfiguration() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("parseConfiguration() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseConfigurationEnvOverrides(t *testing.T) {
+	environ := []string{
+		"RADARR_HOST=http://radarr:7878",
+		"LJG_RADARR_URL=http://other:7878/api/v3/",
+		"LJG_SLUG_CACHE_TTL_DAYS=30",
+		"LJG_REMOVE_UNLISTED_MOVIES=true",
+		"LJG_RADARR_ROOT_PATHS__MOVIES=/mnt/movies",
+		"LJG_USERS__0__COLLECTION_ID=def",
+		"LJG_USERS__0__LISTS=[{\"Owner\":\"owner\",\"Slug\":\"list\",\"CollectionId\":\"ghi\"}]",
+		"LJG_USERS__1__USERNAME=second",
+		"LJG_USERS__1__COLLECTION_ID=jkl",
+		"LJG_USERS__1__JELLYFIN_USER_NAME=guest",
+	}
+
+	got, err := parseConfiguration("config.json", []byte(testJSONConfig), environ)
+	if err != nil {
+		t.Fatalf("parseConfiguration() error = %v", err)
+	}
+
+	wantUsers := []UserData{
+		{Username: "user", CollectionId: "def", JellyfinUserName: "admin", Lists: []LetterboxdList{{Owner: "owner", Slug: "list", CollectionId: "ghi"}}},
+		{Username: "second", CollectionId: "jkl", JellyfinUserName: "guest"},
+	}
+	if got.RadarrUrl != "http://other:7878/api/v3/" {
+		t.Errorf("RadarrUrl = %s, want the override", got.RadarrUrl)
+	}
+	if got.SlugCacheTTLDays != 30 || !got.RemoveUnlistedMovies {
+		t.Errorf("SlugCacheTTLDays = %d, RemoveUnlistedMovies = %v, want 30 and true", got.SlugCacheTTLDays, got.RemoveUnlistedMovies)
+	}
+	if got.RadarrRootPaths["movies"] != "/mnt/movies" || got.RadarrRootPaths["anime_movies"] != "/data/anime" {
+		t.Errorf("RadarrRootPaths = %v, want movies overridden", got.RadarrRootPaths)
+	}
+	if !reflect.DeepEqual(got.Users, wantUsers) {
+		t.Errorf("Users = %+v, want %+v", got.Users, wantUsers)
+	}
+}
+
+func TestParseConfigurationProblems(t *testing.T) {
+	data := `{
+		"JellyfinUrl": "https://jellyfin.example/",
+		"RadarrUrl": "${RADARR_HOST}",
+		"RadarrQualityProfileId": "11",
+		"Users": [{"Username": "user", "CollectionId": "abc", "JellyfinUserName": "admin", "Colection": "x"}],
+		"ScrapeWorker": 4
+	}`
+	environ := []string{"LJG_USERS__3__USERNAME=far", "LJG_UNKNOWN_FIELD=1"}
+
+	_, err := parseConfiguration("config.json", []byte(data), environ)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("parseConfiguration() error = %v, want %v", err, ErrInvalidConfig)
+	}
+	for _, problem := range []string{
+		"RadarrUrl: environment variable RADARR_HOST is not set",
+		"RadarrQualityProfileId: expected an integer, got a string",
+		"Users[0].Colection: unknown key",
+		"ScrapeWorker: unknown key",
+		"LJG_USERS__3__USERNAME: invalid index 3",
+		"LJG_UNKNOWN_FIELD: unknown field UNKNOWN_FIELD",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("parseConfiguration() error = %v, want it to contain %q", err, problem)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	conf := Configuration{
+		RadarrUrl:       "http://radarr:7878/api/v3/",
+		SonarrUrl:       "http://sonarr:8989/api/v3/",
+		RadarrRootPaths: map[string]string{"movies": "/data/movies", "anime_movies": "/data/anime", "series": "/data/tv"},
+		Users: []UserData{
+			{Username: "user", CollectionId: "abc", JellyfinUserName: "admin"},
+			{Username: "User", JellyfinUserName: "guest", Lists: []LetterboxdList{{Owner: "owner"}}},
+		},
+		ScrapeWorkers:               -1,
+		LetterboxdRequestsPerMinute: -60,
+		SyncSchedule:                "*/30 * *",
+		WatchlistOutput:             "playlists",
+		WebhookListenAddress:        ":8080",
+	}
+	want := []string{
+		"JellyfinUrl is empty",
+		`WatchlistOutput: "playlists" is not collection, playlist or both`,
+		"RadarrRootPaths.anime_series is missing",
+		"Users[1] (User): duplicate of Users[0]",
+		"Users[1] (User): Lists[0]: Owner and Slug are required",
+		"Users[1] (User): Lists[0]: CollectionId is empty",
+		"WebhookSecret is empty while WebhookListenAddress is set",
+		"LetterboxdRequestsPerMinute is negative",
+		"ScrapeWorkers is negative",
+		"SyncSchedule: expected exactly 5 fields, found 3: [*/30 * *]",
+	}
+
+	got := conf.validate()
+	if !slices.Equal(got, want) {
+		t.Errorf("validate() = %q, want %q", got, want)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "RadarrUrl", want: "RADARR_URL"},
+		{name: "RadarrQualityProfileId", want: "RADARR_QUALITY_PROFILE_ID"},
+		{name: "SlugCacheTTLDays", want: "SLUG_CACHE_TTL_DAYS"},
+		{name: "Users", want: "USERS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envName(tt.name); got != tt.want {
+				t.Errorf("envName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTreeDates(t *testing.T) {
+	var problems []string
+	checkTree(map[string]any{"Date": "yesterday", "Time": time.Now()}, reflect.TypeOf(struct {
+		Date time.Time
+		Time time.Time
+	}{}), "", &problems)
+
+	if len(problems) != 1 || !strings.Contains(problems[0], "Date") {
+		t.Errorf("checkTree() problems = %q, want only Date", problems)
+	}
+}
@@ -0,0 +1,295 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const lockFilePath = "app.lock"
+
+// The heartbeat of a running app is refreshed every lockHeartbeatInterval, a
+// lock whose heartbeat is older than lockStaleAfter is considered abandoned.
+const (
+	lockHeartbeatInterval = 30 * time.Second
+	lockStaleAfter        = 5 * time.Minute
+)
+
+var ErrLocked = errors.New("app is locked by another run")
+
+// LockInfo is the content of the lock file.
+type LockInfo struct {
+	Pid       int
+	Hostname  string
+	StartedAt time.Time
+	Heartbeat time.Time
+}
+
+func (info LockInfo) String() string {
+	return fmt.Sprintf("pid %d on %s, started at %s, last heartbeat at %s", info.Pid, info.Hostname, info.StartedAt.Format(time.RFC3339), info.Heartbeat.Format(time.RFC3339))
+}
+
+// isStale reports whether the run holding the lock is gone: its heartbeat
+// stopped, or it ran on this host and its process does not exist anymore. A
+// lock holding the pid of the current process was left by a previous run
+// that got the same pid, as the container restarting the app as pid 1.
+func (info LockInfo) isStale(hostname string) bool {
+	if time.Since(info.Heartbeat) > lockStaleAfter {
+		return true
+	}
+	if info.Hostname != hostname {
+		return false
+	}
+	if info.Pid == os.Getpid() {
+		return true
+	}
+
+	process, err := os.FindProcess(info.Pid)
+	if err != nil {
+		return true
+	}
+	return errors.Is(process.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// Lock is held by the running app until Release, its heartbeat is refreshed
+// in the background. The lock file stays open and locked (see tryFlock) so
+// that another run can tell a live lock from the file of a killed one.
+type Lock struct {
+	path string
+	file *os.File
+	info LockInfo
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func LockPath() string {
+	return filepath.Join(basepath, lockFilePath)
+}
+
+// AcquireLock creates the lock file of the app. A stale lock left by a killed
+// run is taken over, a live one gives ErrLocked.
+func AcquireLock() (*Lock, error) {
+	return acquireLock(LockPath(), lockHeartbeatInterval)
+}
+
+func acquireLock(path string, heartbeatInterval time.Duration) (*Lock, error) {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	lock := &Lock{
+		path: path,
+		info: LockInfo{
+			Pid:       os.Getpid(),
+			Hostname:  hostname,
+			StartedAt: now,
+			Heartbeat: now,
+		},
+		stop: make(chan struct{}),
+	}
+
+	// The next attempts follow the removal of the file by the run releasing
+	// it, between its opening and its locking.
+	for attempt := 0; attempt < 3; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		created := err == nil
+		if errors.Is(err, fs.ErrExist) {
+			file, err = os.OpenFile(path, os.O_RDWR, 0)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		locked, err := tryFlock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if !locked {
+			file.Close()
+			holder, err := readLock(path)
+			if err != nil {
+				return nil, fmt.Errorf("%w: lock file %s", ErrLocked, path)
+			}
+			return nil, fmt.Errorf("%w: %s", ErrLocked, holder)
+		}
+		if !isLockFile(path, file) {
+			file.Close()
+			continue
+		}
+
+		// The file may be left by a killed run. Its holder is alive if it
+		// runs on another host, which the lock of the file says nothing
+		// about, or if the file is being written by an older version not
+		// locking it.
+		if !created {
+			holder, err := readLockFile(file)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			if (!flockSupported || holder.Hostname != hostname) && !holder.isStale(hostname) {
+				file.Close()
+				return nil, fmt.Errorf("%w: %s", ErrLocked, holder)
+			}
+			log.Printf("Taking over the stale lock held by %s", holder)
+		}
+
+		lock.file = file
+		if err := lock.write(); err != nil {
+			os.Remove(path)
+			file.Close()
+			return nil, err
+		}
+
+		lock.wg.Add(1)
+		go lock.heartbeat(heartbeatInterval)
+		return lock, nil
+	}
+
+	return nil, fmt.Errorf("%w: lock file %s was recreated by another run", ErrLocked, path)
+}
+
+// isLockFile reports whether file is still the one at path, it is not once
+// removed by the run releasing it.
+func isLockFile(path string, file *os.File) bool {
+	pathStat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	fileStat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return os.SameFile(pathStat, fileStat)
+}
+
+// readLock reads the lock file at path.
+func readLock(path string) (LockInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	defer file.Close()
+	return readLockFile(file)
+}
+
+// readLockFile reads the lock file from its start. Lock files of older
+// versions are empty, they are described by their modification time.
+func readLockFile(file *os.File) (LockInfo, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return LockInfo{}, err
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, stat.Size()))
+	if err != nil {
+		return LockInfo{}, err
+	}
+
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		// A file being written is empty or incomplete, its recent
+		// modification time keeps it held until it is stale.
+		info = LockInfo{Hostname: "unknown host"}
+	}
+	if info.Heartbeat.IsZero() {
+		info.StartedAt = stat.ModTime()
+		info.Heartbeat = stat.ModTime()
+	}
+	return info, nil
+}
+
+func (l *Lock) heartbeat(interval time.Duration) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if !l.isHeld() {
+				log.Printf("Lock file %s was removed or taken over, stopping its heartbeat", l.path)
+				return
+			}
+			l.info.Heartbeat = time.Now()
+			if err := l.write(); err != nil {
+				log.Printf("Failed to refresh the lock heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// isHeld reports whether the lock file is still the one of this run, it is
+// not once removed by unlock.
+func (l *Lock) isHeld() bool {
+	if !isLockFile(l.path, l.file) {
+		return false
+	}
+	info, err := readLock(l.path)
+	return err == nil && info.Pid == l.info.Pid && info.Hostname == l.info.Hostname
+}
+
+// write rewrites the lock file in place, it is locked by this run so it
+// cannot be replaced by another one. The file is truncated after the write,
+// never leaving it empty.
+func (l *Lock) write() error {
+	data, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return l.file.Truncate(int64(len(data)))
+}
+
+// Release stops the heartbeat, removes the lock file when it is still the
+// one of this run and unlocks it.
+func (l *Lock) Release() error {
+	close(l.stop)
+	l.wg.Wait()
+	defer l.file.Close()
+
+	if !isLockFile(l.path, l.file) {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ForceUnlock removes the lock file at path (see LockPath) whatever its state
+// and returns the run that held it.
+func ForceUnlock(path string) (LockInfo, error) {
+	info, err := readLock(path)
+	if err != nil {
+		return LockInfo{}, err
+	}
+	return info, os.Remove(path)
+}
+
+// ReadLock returns the run holding the lock file at path (see LockPath),
+// fs.ErrNotExist when the app is not locked.
+func ReadLock(path string) (LockInfo, error) {
+	return readLock(path)
+}
+
+// IsStale reports whether the run holding the lock is gone, the next run
+// will then take the lock over.
+func (info LockInfo) IsStale() bool {
+	hostname, _ := os.Hostname()
+	return info.isStale(hostname)
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestLock(t *testing.T, path string, info LockInfo) {
+	data, _ := json.Marshal(info)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name    string
+		holder  *LockInfo
+		held    bool
+		empty   time.Duration
+		wantErr error
+	}{
+		{
+			name: "Test no lock",
+		},
+		{
+			name:    "Test live lock",
+			held:    true,
+			wantErr: ErrLocked,
+		},
+		{
+			name:   "Test lock file not held by its live process",
+			holder: &LockInfo{Pid: os.Getppid(), Hostname: hostname, StartedAt: time.Now(), Heartbeat: time.Now()},
+		},
+		{
+			name:   "Test lock of a previous run with the same pid",
+			holder: &LockInfo{Pid: os.Getpid(), Hostname: hostname, StartedAt: time.Now(), Heartbeat: time.Now()},
+		},
+		{
+			name:    "Test live lock of another host",
+			holder:  &LockInfo{Pid: 1 << 22, Hostname: "other-" + hostname, StartedAt: time.Now(), Heartbeat: time.Now()},
+			wantErr: ErrLocked,
+		},
+		{
+			name:   "Test stale heartbeat",
+			holder: &LockInfo{Pid: 1 << 22, Hostname: "other-" + hostname, StartedAt: time.Now().Add(-time.Hour), Heartbeat: time.Now().Add(-time.Hour)},
+		},
+		{
+			name:   "Test dead process",
+			holder: &LockInfo{Pid: 1 << 22, Hostname: hostname, StartedAt: time.Now(), Heartbeat: time.Now()},
+		},
+		{
+			name:    "Test empty lock being written",
+			empty:   time.Second,
+			wantErr: ErrLocked,
+		},
+		{
+			name:  "Test stale empty lock",
+			empty: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.lock")
+			if tt.holder != nil {
+				writeTestLock(t, path, *tt.holder)
+			}
+			if tt.held {
+				holder, err := acquireLock(path, time.Hour)
+				if err != nil {
+					t.Fatalf("acquireLock() returned error: %v", err)
+				}
+				defer holder.Release()
+			}
+			if tt.empty != 0 {
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				modTime := time.Now().Add(-tt.empty)
+				if err := os.Chtimes(path, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lock, err := acquireLock(path, time.Hour)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("acquireLock() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			info, err := readLock(path)
+			if err != nil || info.Pid != os.Getpid() {
+				t.Errorf("lock file = %v (%v), want pid %d", info, err, os.Getpid())
+			}
+			if err := lock.Release(); err != nil {
+				t.Errorf("Release() returned error: %v", err)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("lock file still exists after Release()")
+			}
+		})
+	}
+}
+
+func TestLockHeartbeat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.lock")
+	lock, err := acquireLock(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("acquireLock() returned error: %v", err)
+	}
+	defer lock.Release()
+
+	before, _ := readLock(path)
+	time.Sleep(50 * time.Millisecond)
+	after, _ := readLock(path)
+	if !after.Heartbeat.After(before.Heartbeat) {
+		t.Errorf("heartbeat was not refreshed: %s then %s", before.Heartbeat, after.Heartbeat)
+	}
+}
+
+func TestLockHeartbeatOfRemovedLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.lock")
+	lock, err := acquireLock(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("acquireLock() returned error: %v", err)
+	}
+
+	if _, err := ForceUnlock(path); err != nil {
+		t.Fatalf("ForceUnlock() returned error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file was recreated by the heartbeat of the removed lock")
+	}
+
+	next, err := acquireLock(path, time.Hour)
+	if err != nil {
+		t.Fatalf("acquireLock() after ForceUnlock() returned error: %v", err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Errorf("Release() of the removed lock returned error: %v", err)
+	}
+	if info, err := readLock(path); err != nil || info.Pid != os.Getpid() {
+		t.Errorf("lock file = %v (%v), want the lock taken after ForceUnlock()", info, err)
+	}
+	next.Release()
+}
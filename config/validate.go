@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"diikstra.fr/letterboxd-jellyfin-go/schedule"
+)
+
+// validate returns every problem of the decoded configuration.
+func (c Configuration) validate() []string {
+	var problems []string
+
+	if c.JellyfinUrl == "" {
+		problems = append(problems, "JellyfinUrl is empty")
+	}
+	if c.RadarrUrl == "" {
+		problems = append(problems, "RadarrUrl is empty")
+	}
+
+	switch c.WatchlistOutput {
+	case "", OutputCollection, OutputPlaylist, OutputBoth:
+	default:
+		problems = append(problems, fmt.Sprintf("WatchlistOutput: %q is not %s, %s or %s", c.WatchlistOutput, OutputCollection, OutputPlaylist, OutputBoth))
+	}
+
+	rootPathKeys := []string{"movies", "anime_movies"}
+	if c.SonarrUrl != "" {
+		rootPathKeys = append(rootPathKeys, "series", "anime_series")
+	}
+	for _, key := range rootPathKeys {
+		if c.RadarrRootPaths[key] == "" {
+			problems = append(problems, fmt.Sprintf("RadarrRootPaths.%s is missing", key))
+		}
+	}
+
+	usernames := make(map[string]int)
+	for index, user := range c.Users {
+		name := fmt.Sprintf("Users[%d]", index)
+		if user.Username == "" {
+			problems = append(problems, name+": Username is empty")
+		} else {
+			name = fmt.Sprintf("Users[%d] (%s)", index, user.Username)
+			if first, ok := usernames[strings.ToLower(user.Username)]; ok {
+				problems = append(problems, fmt.Sprintf("%s: duplicate of Users[%d]", name, first))
+			} else {
+				usernames[strings.ToLower(user.Username)] = index
+			}
+		}
+		if user.JellyfinUserName == "" {
+			problems = append(problems, name+": JellyfinUserName is empty")
+		}
+
+		for listIndex, list := range user.Lists {
+			listName := fmt.Sprintf("%s: Lists[%d]", name, listIndex)
+			if list.Owner == "" || list.Slug == "" {
+				problems = append(problems, listName+": Owner and Slug are required")
+			}
+			if list.CollectionId == "" {
+				problems = append(problems, listName+": CollectionId is empty")
+			}
+		}
+	}
+
+	if c.WebhookListenAddress != "" && c.WebhookSecret == "" {
+		problems = append(problems, "WebhookSecret is empty while WebhookListenAddress is set")
+	}
+
+	for index, rule := range c.RadarrRules {
+		if rule.Name == "" {
+			problems = append(problems, fmt.Sprintf("RadarrRules[%d]: Name is empty", index))
+		}
+	}
+
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"RequestTimeoutSeconds", c.RequestTimeoutSeconds},
+		{"RunBudgetMinutes", c.RunBudgetMinutes},
+		{"LetterboxdRequestsPerMinute", c.LetterboxdRequestsPerMinute},
+		{"ScrapeWorkers", c.ScrapeWorkers},
+		{"ConcurrentUsers", c.ConcurrentUsers},
+		{"SlugCacheTTLDays", c.SlugCacheTTLDays},
+		{"FullSyncIntervalHours", c.FullSyncIntervalHours},
+		{"UnlistedGracePeriodHours", c.UnlistedGracePeriodHours},
+		{"StateBackups", c.StateBackups},
+		{"SyncIntervalMinutes", c.SyncIntervalMinutes},
+		{"ScheduleJitterSeconds", c.ScheduleJitterSeconds},
+	}
+	for _, count := range counts {
+		if count.value < 0 {
+			problems = append(problems, fmt.Sprintf("%s is negative", count.name))
+		}
+	}
+
+	schedules := []struct {
+		name       string
+		expression string
+	}{
+		{"SyncSchedule", c.SyncSchedule},
+		{"FullSyncSchedule", c.FullSyncSchedule},
+	}
+	for _, s := range schedules {
+		if s.expression == "" {
+			continue
+		}
+		if _, err := schedule.Parse(s.expression); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.name, err))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,57 @@
+package fetch
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxySettings identifies the SOCKS5 proxy of a fetcher.
+type proxySettings struct {
+	url      string
+	user     string
+	password string
+}
+
+// The HTTP clients are shared by every fetcher, and by the runs of serve, so
+// that their connections are kept alive between requests.
+var (
+	directClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+	proxyClients sync.Map // proxySettings -> *http.Client
+)
+
+// client returns the HTTP client of the requests, going through the proxy
+// when useProxy is set.
+func (f Fetcher) client(useProxy bool) (*http.Client, error) {
+	if !useProxy {
+		return directClient, nil
+	}
+
+	settings := proxySettings{url: f.ProxyUrl, user: f.ProxyUser, password: f.ProxyPass}
+	if client, ok := proxyClients.Load(settings); ok {
+		return client.(*http.Client), nil
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", f.ProxyUrl, &proxy.Auth{
+		User:     f.ProxyUser,
+		Password: f.ProxyPass,
+	}, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+			return contextDialer.DialContext(ctx, network, address)
+		}
+		return dialer.Dial(network, address)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialContext
+	client, _ := proxyClients.LoadOrStore(settings, &http.Client{Transport: transport})
+	return client.(*http.Client), nil
+}
@@ -0,0 +1,122 @@
+package fetch
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// serveSocks5 is a SOCKS5 proxy without authentication accepting CONNECT
+// requests to IPv4 addresses and host names, enough for the tests.
+func serveSocks5(t *testing.T, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			// Greeting: version, number of methods, methods.
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+				return
+			}
+			conn.Write([]byte{5, 0})
+
+			// Request: version, command, reserved, address type.
+			request := make([]byte, 4)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+			var host string
+			switch request[3] {
+			case 1:
+				ip := make([]byte, 4)
+				if _, err := io.ReadFull(conn, ip); err != nil {
+					return
+				}
+				host = net.IP(ip).String()
+			case 3:
+				length := make([]byte, 1)
+				if _, err := io.ReadFull(conn, length); err != nil {
+					return
+				}
+				name := make([]byte, length[0])
+				if _, err := io.ReadFull(conn, name); err != nil {
+					return
+				}
+				host = string(name)
+			default:
+				t.Errorf("unsupported SOCKS5 address type %d", request[3])
+				return
+			}
+			port := make([]byte, 2)
+			if _, err := io.ReadFull(conn, port); err != nil {
+				return
+			}
+
+			target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+			if err != nil {
+				conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer target.Close()
+			conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+			go io.Copy(target, conn)
+			io.Copy(conn, target)
+		}()
+	}
+}
+
+func TestFetchDataReusesConnections(t *testing.T) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveSocks5(t, listener)
+
+	tests := []struct {
+		name     string
+		useProxy bool
+	}{
+		{name: "Test direct requests", useProxy: false},
+		{name: "Test proxied requests", useProxy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connections.Store(0)
+			// Each request uses a copy of the fetcher, as the clients do.
+			for range 3 {
+				fetcher := Fetcher{ProxyUrl: listener.Addr().String(), Retry: &NoRetry}
+				if _, err := fetcher.FetchData(FetcherParams{Method: "GET", Url: server.URL, UseProxy: tt.useProxy}); err != nil {
+					t.Fatalf("FetchData() returned error: %v", err)
+				}
+			}
+			if got := connections.Load(); got != 1 {
+				t.Errorf("server accepted %d connections, want 1", got)
+			}
+		})
+	}
+}
@@ -7,12 +7,11 @@ import (
 	"errors"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"slices"
-
-	"golang.org/x/net/proxy"
+	"strings"
+	"time"
 )
 
 type Header map[string]string
@@ -26,39 +25,79 @@ type FetcherParams struct {
 	Params       Param
 	UseProxy     bool
 	WantErrCodes []int
+	// Timeout bounds each attempt of this request, overriding Fetcher.Timeout.
+	Timeout time.Duration
+	// Retry overrides Fetcher.Retry for this request.
+	Retry *RetryPolicy
 }
 
 type FetcherClient interface {
 	FetchData(fp FetcherParams) ([]byte, error)
+	FetchDataContext(ctx context.Context, fp FetcherParams) ([]byte, error)
 }
 
+// DefaultTimeout is used for every request when neither the Fetcher nor the
+// FetcherParams define a timeout.
+const DefaultTimeout = 30 * time.Second
+
+// Size of the response body kept in a StatusError.
+const maxErrorBodySize = 512
+
 type Fetcher struct {
 	ProxyUrl  string
 	ProxyUser string
 	ProxyPass string
+	Timeout   time.Duration
+	// Retry is shared by every request of the fetcher, nil means
+	// DefaultRetryPolicy.
+	Retry *RetryPolicy
+	// DryRun makes the fetcher refuse every request that is not a GET or HEAD.
+	DryRun bool
+	// RateLimits holds the rate limiter of each host name, shared by every
+	// copy of the fetcher. Hosts without a limiter are not limited.
+	RateLimits map[string]*RateLimiter
 }
 
+var ErrDryRun = errors.New("mutating request refused in dry-run mode")
+
 func (f Fetcher) FetchData(fp FetcherParams) ([]byte, error) {
-	client := &http.Client{}
+	return f.FetchDataContext(context.Background(), fp)
+}
 
-	if fp.UseProxy {
-		dialer, err := proxy.SOCKS5("tcp", f.ProxyUrl, &proxy.Auth{
-			User:     f.ProxyUser,
-			Password: f.ProxyPass,
-		}, proxy.Direct)
-		if err != nil {
-			log.Println("Failed to initialize proxy.")
-			return nil, err
-		}
+func (f Fetcher) requestTimeout(fp FetcherParams) time.Duration {
+	if fp.Timeout > 0 {
+		return fp.Timeout
+	}
+	if f.Timeout > 0 {
+		return f.Timeout
+	}
+	return DefaultTimeout
+}
 
-		dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
-			return dialer.Dial(network, address)
-		}
-		transport := &http.Transport{
-			DialContext:       dialContext,
-			DisableKeepAlives: true,
-		}
-		client = &http.Client{Transport: transport}
+func (f Fetcher) retryPolicy(fp FetcherParams) RetryPolicy {
+	if fp.Retry != nil {
+		return *fp.Retry
+	}
+	if f.Retry != nil {
+		return *f.Retry
+	}
+	return DefaultRetryPolicy
+}
+
+// FetchDataContext makes the request described by fp, retrying it according
+// to the retry policy. The request is aborted as soon as ctx is done, each
+// attempt is bounded by the request timeout and waits for the rate limiter of
+// the host.
+func (f Fetcher) FetchDataContext(ctx context.Context, fp FetcherParams) ([]byte, error) {
+	if f.DryRun && fp.Method != http.MethodGet && fp.Method != http.MethodHead {
+		log.Printf("Dry-run: refusing %s %s", fp.Method, fp.Url)
+		return nil, ErrDryRun
+	}
+
+	client, err := f.client(fp.UseProxy)
+	if err != nil {
+		log.Println("Failed to initialize proxy.")
+		return nil, err
 	}
 
 	baseUrl, err := url.Parse(fp.Url)
@@ -73,17 +112,43 @@ func (f Fetcher) FetchData(fp FetcherParams) ([]byte, error) {
 	}
 	baseUrl.RawQuery = params.Encode()
 
-	bodyBuffer := &bytes.Buffer{}
+	var jsonBytes []byte
 	if fp.Body != nil {
-		jsonBytes, err := json.Marshal(fp.Body)
+		jsonBytes, err = json.Marshal(fp.Body)
 		if err != nil {
 			log.Println("Failed to encode req body in bytes.")
 			return nil, err
 		}
-		bodyBuffer = bytes.NewBuffer(jsonBytes)
 	}
 
-	req, err := http.NewRequest(fp.Method, baseUrl.String(), bodyBuffer)
+	policy := f.retryPolicy(fp)
+	limiter := f.RateLimits[baseUrl.Hostname()]
+	for attempt := 1; ; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		body, err := f.fetchOnce(ctx, client, fp, baseUrl.String(), jsonBytes)
+		if err == nil {
+			return body, nil
+		}
+		if attempt >= policy.MaxAttempts || !policy.isRetryable(ctx, fp.Method, err) {
+			return nil, err
+		}
+
+		delay := policy.delay(attempt, err)
+		log.Printf("Attempt %d/%d failed for %s, retrying in %s: %v", attempt, policy.MaxAttempts, fp.Url, delay.Round(time.Second), err)
+		if err := Sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f Fetcher) fetchOnce(ctx context.Context, client *http.Client, fp FetcherParams, reqUrl string, jsonBytes []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.requestTimeout(fp))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, fp.Method, reqUrl, bytes.NewReader(jsonBytes))
 	if err != nil {
 		log.Println("Failed to initialize request.")
 		return nil, err
@@ -100,12 +165,19 @@ func (f Fetcher) FetchData(fp FetcherParams) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
-	if fp.WantErrCodes == nil && resp.StatusCode != 200 {
-		log.Printf("Got status code %d instead of wanted 200\nUrl : %s", resp.StatusCode, fp.Url)
-		return nil, errors.New("failed to get 200 status code")
-	} else if fp.WantErrCodes != nil && !slices.Contains(fp.WantErrCodes, resp.StatusCode) {
-		log.Printf("Got status code %d instead of wanted %v\nUrl : %s", resp.StatusCode, fp.WantErrCodes, fp.Url)
-		return nil, errors.New("failed to get wanted status code")
+	wantCodes := fp.WantErrCodes
+	if wantCodes == nil {
+		wantCodes = []int{200}
+	}
+	if !slices.Contains(wantCodes, resp.StatusCode) {
+		log.Printf("Got status code %d instead of wanted %v\nUrl : %s", resp.StatusCode, wantCodes, fp.Url)
+		errorBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Url:        fp.Url,
+			RetryAfter: parseRetryAfter(resp),
+			Body:       strings.TrimSpace(string(errorBody)),
+		}
 	}
 
 	body, err := io.ReadAll(resp.Body)
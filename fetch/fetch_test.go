@@ -0,0 +1,95 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchDataDryRun(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	fetcher := Fetcher{DryRun: true}
+
+	if _, err := fetcher.FetchData(FetcherParams{Method: "GET", Url: server.URL}); err != nil {
+		t.Errorf("FetchData() GET in dry-run returned error: %v", err)
+	}
+	for _, method := range []string{"POST", "DELETE", "PUT"} {
+		if _, err := fetcher.FetchData(FetcherParams{Method: method, Url: server.URL}); !errors.Is(err, ErrDryRun) {
+			t.Errorf("FetchData() %s in dry-run error = %v, want %v", method, err, ErrDryRun)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Server received %d requests, want 1", requests)
+	}
+}
+
+func TestFetchDataContextNoResponse(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tests := []struct {
+		name    string
+		fetcher Fetcher
+		// Returns the context of the request, cancelled by the returned func.
+		context func() (context.Context, context.CancelFunc)
+		want    error
+	}{
+		{
+			name:    "Test context deadline",
+			fetcher: Fetcher{Retry: &NoRetry},
+			context: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+		{
+			name:    "Test context cancelled",
+			fetcher: Fetcher{Retry: &NoRetry},
+			context: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want: context.Canceled,
+		},
+		{
+			name:    "Test request timeout",
+			fetcher: Fetcher{Timeout: 50 * time.Millisecond, Retry: &NoRetry},
+			context: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			want: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.context()
+			defer cancel()
+
+			start := time.Now()
+			_, err := tt.fetcher.FetchDataContext(ctx, FetcherParams{Method: "GET", Url: server.URL})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("FetchDataContext() error = %v, want %v", err, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("FetchDataContext() returned after %s, want it to stop at the timeout", elapsed)
+			}
+		})
+	}
+}
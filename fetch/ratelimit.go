@@ -0,0 +1,82 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by every request made to a host. The
+// bucket refills at the configured rate and holds at most Burst tokens, each
+// request takes one token and waits for it when the bucket is empty.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+var ErrInvalidRate = errors.New("invalid rate limit")
+
+// NewRateLimiter allows requestsPerMinute requests per minute on average and
+// up to burst requests at once. A burst below 1 is raised to 1, a rate below
+// 1 gives ErrInvalidRate.
+func NewRateLimiter(requestsPerMinute int, burst int) (*RateLimiter, error) {
+	if requestsPerMinute < 1 {
+		return nil, fmt.Errorf("%w: %d requests per minute", ErrInvalidRate, requestsPerMinute)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		interval: time.Minute / time.Duration(requestsPerMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}, nil
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it. Tokens can go negative so that waiting callers are served in
+// order.
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.tokens += float64(now.Sub(rl.last)) / float64(rl.interval)
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+
+	rl.tokens--
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens * float64(rl.interval))
+}
+
+// cancel gives back a token reserved by a caller that stopped waiting.
+func (rl *RateLimiter) cancel() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.tokens++
+}
+
+// Wait blocks until a request can be made or ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	if rl == nil {
+		return nil
+	}
+
+	if err := Sleep(ctx, rl.reserve()); err != nil {
+		rl.cancel()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	// 600 requests per minute is one every 100ms.
+	limiter, err := NewRateLimiter(600, 2)
+	if err != nil {
+		t.Fatalf("NewRateLimiter() returned error: %v", err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Errorf("Wait() returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The burst of 2 is immediate, the 3 other requests wait 100ms each.
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > time.Second {
+		t.Errorf("5 requests took %s, want about 300ms", elapsed)
+	}
+
+	for _, requestsPerMinute := range []int{0, -1} {
+		if _, err := NewRateLimiter(requestsPerMinute, 1); !errors.Is(err, ErrInvalidRate) {
+			t.Errorf("NewRateLimiter(%d) error = %v, want %v", requestsPerMinute, err, ErrInvalidRate)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err == nil {
+		t.Errorf("Wait() on an empty bucket returned no error before the deadline")
+	}
+}
+
+func TestFetchDataRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	serverUrl, _ := url.Parse(server.URL)
+	limiter, err := NewRateLimiter(600, 1)
+	if err != nil {
+		t.Fatalf("NewRateLimiter() returned error: %v", err)
+	}
+	fetcher := Fetcher{
+		RateLimits: map[string]*RateLimiter{
+			serverUrl.Hostname(): limiter,
+		},
+	}
+
+	start := time.Now()
+	for range 3 {
+		if _, err := fetcher.FetchData(FetcherParams{Method: "GET", Url: server.URL}); err != nil {
+			t.Fatalf("FetchData() returned error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3 rate limited requests took %s, want at least 200ms", elapsed)
+	}
+}
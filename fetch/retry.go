@@ -0,0 +1,149 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var ErrUnexpectedStatus = errors.New("unexpected status code")
+
+// StatusError is returned when the response status code is not one of the
+// wanted codes. RetryAfter holds the delay requested by the server, if any.
+type StatusError struct {
+	StatusCode int
+	Url        string
+	RetryAfter time.Duration
+	// Body is the beginning of the response body, APIs usually explain why
+	// the request was rejected in it.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("got status code %d for %s: %s", e.StatusCode, e.Url, e.Body)
+	}
+	return fmt.Sprintf("got status code %d for %s", e.StatusCode, e.Url)
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// RetryPolicy describes how failed requests are retried. Network errors and
+// status codes listed in RetryableStatus are retried with an exponential
+// backoff and full jitter, a Retry-After header on 429 and 503 responses
+// takes precedence over the computed delay.
+type RetryPolicy struct {
+	MaxAttempts     int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	RetryableStatus []int
+	// RetryNonIdempotent allows retrying POST and PATCH requests. They are
+	// not retried by default: a request applied by the server whose response
+	// got lost would be applied twice.
+	RetryNonIdempotent bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	BaseDelay:       2 * time.Second,
+	MaxDelay:        time.Minute,
+	RetryableStatus: []int{408, 429, 500, 502, 503, 504},
+}
+
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// Backoff returns the delay to wait before the given retry (1 for the first
+// retry), picked uniformly in [0, min(MaxDelay, BaseDelay * 2^(retry-1))].
+func (rp RetryPolicy) Backoff(retry int) time.Duration {
+	if rp.BaseDelay <= 0 {
+		return 0
+	}
+
+	ceiling := rp.BaseDelay
+	for i := 1; i < retry && (rp.MaxDelay <= 0 || ceiling < rp.MaxDelay); i++ {
+		ceiling *= 2
+	}
+	if rp.MaxDelay > 0 && ceiling > rp.MaxDelay {
+		ceiling = rp.MaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func (rp RetryPolicy) isRetryable(ctx context.Context, method string, err error) bool {
+	// The parent context being done is final, only the per-request timeout
+	// is worth another attempt.
+	if ctx.Err() != nil {
+		return false
+	}
+	if !rp.RetryNonIdempotent && !isIdempotent(method) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return slices.Contains(rp.RetryableStatus, statusErr.StatusCode)
+	}
+	return true
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func (rp RetryPolicy) delay(retry int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+	return rp.Backoff(retry)
+}
+
+// parseRetryAfter reads a Retry-After header, either a number of seconds or
+// an HTTP date. Only 429 and 503 responses are expected to carry it.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// Sleep pauses for the given duration, returning early with the context error
+// if ctx is done before.
+func Sleep(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
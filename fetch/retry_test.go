@@ -0,0 +1,160 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchDataRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       time.Millisecond,
+		MaxDelay:        5 * time.Millisecond,
+		RetryableStatus: DefaultRetryPolicy.RetryableStatus,
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		// Overrides RetryNonIdempotent of the policy.
+		retryNonIdempotent bool
+		statusCodes        []int
+		wantAttempts       int
+		wantErr            bool
+		wantStatus         int
+	}{
+		{
+			name:         "Test success after retryable errors",
+			statusCodes:  []int{503, 429, 200},
+			wantAttempts: 3,
+		},
+		{
+			name:         "Test non retryable status",
+			statusCodes:  []int{404, 200},
+			wantAttempts: 1,
+			wantErr:      true,
+			wantStatus:   404,
+		},
+		{
+			name:         "Test max attempts reached",
+			statusCodes:  []int{500, 502, 504, 200},
+			wantAttempts: 3,
+			wantErr:      true,
+			wantStatus:   504,
+		},
+		{
+			name:         "Test POST not retried",
+			method:       "POST",
+			statusCodes:  []int{503, 200},
+			wantAttempts: 1,
+			wantErr:      true,
+			wantStatus:   503,
+		},
+		{
+			name:               "Test POST retried when allowed",
+			method:             "POST",
+			retryNonIdempotent: true,
+			statusCodes:        []int{503, 200},
+			wantAttempts:       2,
+		},
+		{
+			name:         "Test DELETE retried",
+			method:       "DELETE",
+			statusCodes:  []int{503, 200},
+			wantAttempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCodes[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			policy := policy
+			policy.RetryNonIdempotent = tt.retryNonIdempotent
+
+			_, err := Fetcher{Retry: &policy}.FetchData(FetcherParams{
+				Method: method,
+				Url:    server.URL,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var statusErr *StatusError
+			if tt.wantErr && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus) {
+				t.Errorf("FetchData() error = %v, want status %d", err, tt.wantStatus)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("FetchData() made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestFetchDataContextCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Fetcher{}.FetchDataContext(ctx, FetcherParams{
+		Method: "GET",
+		Url:    server.URL,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FetchDataContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("FetchDataContext() did not stop waiting on context cancellation")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		header     string
+		want       time.Duration
+	}{
+		{name: "Test seconds on 429", statusCode: 429, header: "120", want: 2 * time.Minute},
+		{name: "Test seconds on 503", statusCode: 503, header: "5", want: 5 * time.Second},
+		{name: "Test ignored on 500", statusCode: 500, header: "5", want: 0},
+		{name: "Test invalid header", statusCode: 429, header: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.statusCode, Header: http.Header{}}
+			resp.Header.Set("Retry-After", tt.header)
+			if got := parseRetryAfter(resp); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for retry, ceiling := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		for i := 0; i < 100; i++ {
+			if got := policy.Backoff(retry); got < 0 || got > ceiling {
+				t.Fatalf("Backoff(%d) = %v, want in [0, %v]", retry, got, ceiling)
+			}
+		}
+	}
+}
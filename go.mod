@@ -2,12 +2,17 @@ module diikstra.fr/letterboxd-jellyfin-go
 
 go 1.22.1
 
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/joho/godotenv v1.5.1
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/net v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	github.com/stretchr/testify v1.9.0 // indirect
-	golang.org/x/net v0.22.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -23,23 +23,22 @@ func PrintNode(n *html.Node) {
 	fmt.Println(buf.String())
 }
 
+// Every non empty field of the selector must match. ClassNames is a space
+// separated list of class tokens that must all be present on the node.
 func isNodeMatchingSelector(node *html.Node, selector *HtmlSelector) bool {
-	if node.Type == html.ElementNode &&
-		(selector.Tag == "" || node.Data == selector.Tag) {
-		for _, attr := range node.Attr {
-			switch attr.Key {
-			case "id":
-				if attr.Val == selector.Id {
-					return true
-				}
-			case "class":
-				if strings.Contains(attr.Val, selector.ClassNames) {
-					return true
-				}
-			}
+	if node.Type != html.ElementNode ||
+		(selector.Tag != "" && node.Data != selector.Tag) {
+		return false
+	}
+	if selector.Id != "" && GetAttribute(node, "id") != selector.Id {
+		return false
+	}
+	for _, class := range strings.Fields(selector.ClassNames) {
+		if !HasClass(node, class) {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
 func GetAttribute(parentNode *html.Node, attribute string) string {
@@ -51,6 +50,24 @@ func GetAttribute(parentNode *html.Node, attribute string) string {
 	return ""
 }
 
+// GetText returns the concatenated text of the node and its descendants.
+func GetText(node *html.Node) string {
+	var builder strings.Builder
+
+	var crawler func(*html.Node)
+	crawler = func(node *html.Node) {
+		if node.Type == html.TextNode {
+			builder.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			crawler(child)
+		}
+	}
+	crawler(node)
+
+	return builder.String()
+}
+
 // Using a recursive solution, this method search for a HTML node matching
 // the HTML selectors values (ClassNames, Id, Tag)
 func GetNodeByClass(parentNode *html.Node, selector *HtmlSelector) []*html.Node {
@@ -0,0 +1,594 @@
+package gosoup
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var ErrInvalidSelector = errors.New("invalid css selector")
+
+type matcher func(*html.Node) bool
+
+// A compound selector is a sequence of simple selectors without combinator,
+// like "div.film-poster[data-target-link]".
+type compound struct {
+	matchers []matcher
+}
+
+func (c compound) match(node *html.Node) bool {
+	if node.Type != html.ElementNode {
+		return false
+	}
+	for _, m := range c.matchers {
+		if !m(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// A complex selector chains compounds with combinators. combinators[i] links
+// compounds[i] to compounds[i+1] and is one of ' ', '>', '+' or '~'.
+type complexSelector struct {
+	compounds   []compound
+	combinators []byte
+}
+
+func (cs complexSelector) match(node *html.Node) bool {
+	return cs.matchAt(node, len(cs.compounds)-1)
+}
+
+// Selectors are evaluated from right to left, walking up the tree (or back
+// through the siblings) for every combinator.
+func (cs complexSelector) matchAt(node *html.Node, index int) bool {
+	if !cs.compounds[index].match(node) {
+		return false
+	}
+	if index == 0 {
+		return true
+	}
+
+	switch cs.combinators[index-1] {
+	case '>':
+		parent := node.Parent
+		return parent != nil && cs.matchAt(parent, index-1)
+	case '+':
+		sibling := previousElementSibling(node)
+		return sibling != nil && cs.matchAt(sibling, index-1)
+	case '~':
+		for sibling := previousElementSibling(node); sibling != nil; sibling = previousElementSibling(sibling) {
+			if cs.matchAt(sibling, index-1) {
+				return true
+			}
+		}
+	default:
+		for parent := node.Parent; parent != nil; parent = parent.Parent {
+			if cs.matchAt(parent, index-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Selector is a compiled CSS selector group (comma separated selectors).
+type Selector struct {
+	raw    string
+	groups []complexSelector
+}
+
+func (s *Selector) String() string {
+	return s.raw
+}
+
+// Match reports whether the node matches at least one selector of the group.
+func (s *Selector) Match(node *html.Node) bool {
+	for _, group := range s.groups {
+		if group.match(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns every descendant of root matching the selector, in document
+// order. The root node itself is never returned.
+func (s *Selector) Select(root *html.Node) []*html.Node {
+	var nodes []*html.Node
+
+	var crawler func(*html.Node)
+	crawler = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if s.Match(child) {
+				nodes = append(nodes, child)
+			}
+			crawler(child)
+		}
+	}
+	crawler(root)
+
+	return nodes
+}
+
+// SelectFirst returns the first descendant of root matching the selector, or
+// nil if there is none.
+func (s *Selector) SelectFirst(root *html.Node) *html.Node {
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if s.Match(child) {
+			return child
+		}
+		if node := s.SelectFirst(child); node != nil {
+			return node
+		}
+	}
+	return nil
+}
+
+// Compile parses a CSS selector. Supported syntax: type and universal
+// selectors, #id, .class, attribute selectors ([attr], =, ~=, |=, ^=, $=, *=),
+// the descendant, child (>), adjacent (+) and general sibling (~) combinators,
+// :first-child, :last-child, :only-child, :nth-child(), :nth-last-child(),
+// :not() and comma separated groups.
+func Compile(selector string) (*Selector, error) {
+	p := &parser{input: selector}
+	groups, err := p.parseGroups()
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{raw: selector, groups: groups}, nil
+}
+
+// MustCompile is like Compile but panics if the selector is invalid. It is
+// meant for package level selectors that are known to be valid.
+func MustCompile(selector string) *Selector {
+	s, err := Compile(selector)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Select compiles the selector and returns every matching descendant of node.
+func Select(node *html.Node, selector string) ([]*html.Node, error) {
+	s, err := Compile(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.Select(node), nil
+}
+
+// SelectFirst compiles the selector and returns the first matching descendant
+// of node, or nil if there is none.
+func SelectFirst(node *html.Node, selector string) (*html.Node, error) {
+	s, err := Compile(selector)
+	if err != nil {
+		return nil, err
+	}
+	return s.SelectFirst(node), nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w %q at offset %d: %s", ErrInvalidSelector, p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() bool {
+	start := p.pos
+	for !p.eof() && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *parser) parseGroups() ([]complexSelector, error) {
+	var groups []complexSelector
+	for {
+		p.skipSpaces()
+		group, err := p.parseComplex()
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+
+		p.skipSpaces()
+		if p.eof() {
+			return groups, nil
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("unexpected character %q", p.peek())
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) parseComplex() (complexSelector, error) {
+	var cs complexSelector
+
+	first, err := p.parseCompound()
+	if err != nil {
+		return cs, err
+	}
+	cs.compounds = append(cs.compounds, first)
+
+	for {
+		hadSpace := p.skipSpaces()
+		if p.eof() || p.peek() == ',' || p.peek() == ')' {
+			return cs, nil
+		}
+
+		combinator := byte(' ')
+		switch p.peek() {
+		case '>', '+', '~':
+			combinator = p.peek()
+			p.pos++
+			p.skipSpaces()
+		default:
+			if !hadSpace {
+				return cs, p.errorf("unexpected character %q", p.peek())
+			}
+		}
+
+		next, err := p.parseCompound()
+		if err != nil {
+			return cs, err
+		}
+		cs.combinators = append(cs.combinators, combinator)
+		cs.compounds = append(cs.compounds, next)
+	}
+}
+
+func (p *parser) parseCompound() (compound, error) {
+	var c compound
+	hasType := false
+
+	if p.peek() == '*' {
+		p.pos++
+		hasType = true
+	} else if isNameChar(p.peek()) {
+		hasType = true
+		tag := strings.ToLower(p.parseName())
+		c.matchers = append(c.matchers, func(node *html.Node) bool {
+			return node.Data == tag
+		})
+	}
+
+	for !p.eof() {
+		var m matcher
+		var err error
+
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id := p.parseName()
+			if id == "" {
+				return c, p.errorf("expected id after '#'")
+			}
+			m = func(node *html.Node) bool {
+				return GetAttribute(node, "id") == id
+			}
+		case '.':
+			p.pos++
+			class := p.parseName()
+			if class == "" {
+				return c, p.errorf("expected class name after '.'")
+			}
+			m = func(node *html.Node) bool {
+				return HasClass(node, class)
+			}
+		case '[':
+			m, err = p.parseAttribute()
+		case ':':
+			m, err = p.parsePseudo()
+		}
+
+		if err != nil {
+			return c, err
+		}
+		if m == nil {
+			break
+		}
+		c.matchers = append(c.matchers, m)
+	}
+
+	if !hasType && len(c.matchers) == 0 {
+		return c, p.errorf("expected selector")
+	}
+	return c, nil
+}
+
+func (p *parser) parseName() string {
+	start := p.pos
+	for !p.eof() && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *parser) parseAttribute() (matcher, error) {
+	p.pos++ // '['
+	p.skipSpaces()
+	key := strings.ToLower(p.parseName())
+	if key == "" {
+		return nil, p.errorf("expected attribute name")
+	}
+	p.skipSpaces()
+
+	if p.peek() == ']' {
+		p.pos++
+		return func(node *html.Node) bool {
+			_, ok := lookupAttribute(node, key)
+			return ok
+		}, nil
+	}
+
+	operator := ""
+	switch p.peek() {
+	case '=':
+		operator = "="
+		p.pos++
+	case '~', '|', '^', '$', '*':
+		operator = p.input[p.pos : p.pos+1]
+		p.pos++
+		if p.peek() != '=' {
+			return nil, p.errorf("expected '=' after %q", operator)
+		}
+		p.pos++
+	default:
+		return nil, p.errorf("unexpected character %q in attribute selector", p.peek())
+	}
+	p.skipSpaces()
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.peek() != ']' {
+		return nil, p.errorf("expected ']'")
+	}
+	p.pos++
+
+	var compare func(string) bool
+	switch operator {
+	case "=":
+		compare = func(v string) bool { return v == value }
+	case "~":
+		compare = func(v string) bool { return value != "" && containsToken(v, value) }
+	case "|":
+		compare = func(v string) bool { return v == value || strings.HasPrefix(v, value+"-") }
+	case "^":
+		compare = func(v string) bool { return value != "" && strings.HasPrefix(v, value) }
+	case "$":
+		compare = func(v string) bool { return value != "" && strings.HasSuffix(v, value) }
+	case "*":
+		compare = func(v string) bool { return value != "" && strings.Contains(v, value) }
+	}
+
+	return func(node *html.Node) bool {
+		v, ok := lookupAttribute(node, key)
+		return ok && compare(v)
+	}, nil
+}
+
+func (p *parser) parseValue() (string, error) {
+	quote := p.peek()
+	if quote != '"' && quote != '\'' {
+		value := p.parseName()
+		if value == "" {
+			return "", p.errorf("expected attribute value")
+		}
+		return value, nil
+	}
+
+	p.pos++
+	end := strings.IndexByte(p.input[p.pos:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated string")
+	}
+	value := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+	return value, nil
+}
+
+func (p *parser) parsePseudo() (matcher, error) {
+	p.pos++ // ':'
+	name := strings.ToLower(p.parseName())
+
+	switch name {
+	case "first-child":
+		return nthMatcher(0, 1, false), nil
+	case "last-child":
+		return nthMatcher(0, 1, true), nil
+	case "only-child":
+		first, last := nthMatcher(0, 1, false), nthMatcher(0, 1, true)
+		return func(node *html.Node) bool {
+			return first(node) && last(node)
+		}, nil
+	case "nth-child", "nth-last-child":
+		argument, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		a, b, err := parseNth(argument)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return nthMatcher(a, b, name == "nth-last-child"), nil
+	case "not":
+		if p.peek() != '(' {
+			return nil, p.errorf("expected '(' after :not")
+		}
+		p.pos++
+		p.skipSpaces()
+		var inner []complexSelector
+		for {
+			group, err := p.parseComplex()
+			if err != nil {
+				return nil, err
+			}
+			inner = append(inner, group)
+			p.skipSpaces()
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+			p.skipSpaces()
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+		p.pos++
+		negated := Selector{groups: inner}
+		return func(node *html.Node) bool {
+			return !negated.Match(node)
+		}, nil
+	}
+
+	return nil, p.errorf("unsupported pseudo-class %q", name)
+}
+
+func (p *parser) parseArgument() (string, error) {
+	if p.peek() != '(' {
+		return "", p.errorf("expected '('")
+	}
+	end := strings.IndexByte(p.input[p.pos:], ')')
+	if end < 0 {
+		return "", p.errorf("expected ')'")
+	}
+	argument := p.input[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	return strings.TrimSpace(argument), nil
+}
+
+// parseNth parses the an+b micro syntax used by :nth-child.
+func parseNth(argument string) (int, int, error) {
+	argument = strings.ToLower(strings.ReplaceAll(argument, " ", ""))
+	switch argument {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	}
+
+	nIndex := strings.IndexByte(argument, 'n')
+	if nIndex < 0 {
+		b, err := strconv.Atoi(argument)
+		return 0, b, err
+	}
+
+	a := 1
+	switch coefficient := argument[:nIndex]; coefficient {
+	case "", "+":
+	case "-":
+		a = -1
+	default:
+		var err error
+		if a, err = strconv.Atoi(coefficient); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	b := 0
+	if offset := argument[nIndex+1:]; offset != "" {
+		var err error
+		if b, err = strconv.Atoi(offset); err != nil {
+			return 0, 0, err
+		}
+	}
+	return a, b, nil
+}
+
+// nthMatcher matches elements whose 1-based position among their element
+// siblings is a*n+b for some n >= 0.
+func nthMatcher(a int, b int, fromEnd bool) matcher {
+	return func(node *html.Node) bool {
+		if node.Parent == nil {
+			return false
+		}
+
+		position := 1
+		next := previousElementSibling
+		if fromEnd {
+			next = nextElementSibling
+		}
+		for sibling := next(node); sibling != nil; sibling = next(sibling) {
+			position++
+		}
+
+		if a == 0 {
+			return position == b
+		}
+		return (position-b)%a == 0 && (position-b)/a >= 0
+	}
+}
+
+func previousElementSibling(node *html.Node) *html.Node {
+	for sibling := node.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func nextElementSibling(node *html.Node) *html.Node {
+	for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func lookupAttribute(node *html.Node, key string) (string, bool) {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// HasClass reports whether the class attribute of the node contains the exact
+// class token.
+func HasClass(node *html.Node, class string) bool {
+	return containsToken(GetAttribute(node, "class"), class)
+}
+
+func containsToken(list string, token string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == token {
+			return true
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isNameChar(c byte) bool {
+	return c == '-' || c == '_' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c >= 0x80
+}
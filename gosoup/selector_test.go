@@ -0,0 +1,117 @@
+package gosoup
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const testDocument = `<html><body class="film backdropped" data-tmdb-id="348">
+<ul id="posters">
+	<li class="poster-container"><div class="really-lazy-load poster film-poster" data-target-link="/film/alien/">A</div></li>
+	<li class="poster-container"><div class="poster film-poster-large" data-target-link="/film/aliens/">B</div></li>
+	<li class="poster-container"><div class="poster film-poster">C</div></li>
+	<li class="poster-container hidden"><div class="poster film-poster" data-target-link="/film/alien-3/">D</div></li>
+</ul>
+<section><div class="film-poster" data-target-link="/list/favorites/">E</div></section>
+</body></html>`
+
+func texts(nodes []*html.Node) []string {
+	var result []string
+	for _, node := range nodes {
+		var builder strings.Builder
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.TextNode {
+				builder.WriteString(child.Data)
+			}
+		}
+		result = append(result, builder.String())
+	}
+	return result
+}
+
+func TestSelect(t *testing.T) {
+	document, err := html.Parse(strings.NewReader(testDocument))
+	if err != nil {
+		t.Fatalf("Failed to parse test document: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		want     []string
+	}{
+		{
+			name:     "Test exact class token and attribute presence",
+			selector: "div.film-poster[data-target-link]",
+			want:     []string{"A", "D", "E"},
+		},
+		{
+			name:     "Test child combinator",
+			selector: "li > div.film-poster",
+			want:     []string{"A", "C", "D"},
+		},
+		{
+			name:     "Test descendant combinator with id",
+			selector: "#posters div.poster",
+			want:     []string{"A", "B", "C", "D"},
+		},
+		{
+			name:     "Test attribute prefix",
+			selector: "[data-target-link^='/film/alien']",
+			want:     []string{"A", "B", "D"},
+		},
+		{
+			name:     "Test attribute equality",
+			selector: `div[data-target-link="/film/aliens/"]`,
+			want:     []string{"B"},
+		},
+		{
+			name:     "Test nth-child",
+			selector: "li:nth-child(2n+1) > div",
+			want:     []string{"A", "C"},
+		},
+		{
+			name:     "Test not",
+			selector: "li:not(.hidden) > .film-poster",
+			want:     []string{"A", "C"},
+		},
+		{
+			name:     "Test selector group",
+			selector: "li:first-child div, section div",
+			want:     []string{"A", "E"},
+		},
+		{
+			name:     "Test adjacent sibling",
+			selector: "li:last-child + li, li.hidden ~ li, li:nth-last-child(2) + li > div",
+			want:     []string{"D"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Select(document, tt.selector)
+			if err != nil {
+				t.Fatalf("Select() returned error: %v", err)
+			}
+			if !reflect.DeepEqual(texts(got), tt.want) {
+				t.Errorf("Select(%q) = %v, want %v", tt.selector, texts(got), tt.want)
+			}
+		})
+	}
+
+	body, _ := SelectFirst(document, "body.film[data-tmdb-id]")
+	if body == nil || GetAttribute(body, "data-tmdb-id") != "348" {
+		t.Errorf("SelectFirst() did not find the film body")
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, selector := range []string{"", "div >", "[data", ".", "div:hover", "li:nth-child(x)", "a,,b"} {
+		if _, err := Compile(selector); !errors.Is(err, ErrInvalidSelector) {
+			t.Errorf("Compile(%q) error = %v, want ErrInvalidSelector", selector, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	lt "diikstra.fr/letterboxd-jellyfin-go/letterboxd"
+)
+
+// importExport syncs the user from a Letterboxd data export instead of
+// scraping their profile. The watchlist entries missing from the collection
+// are added, the films watched on Letterboxd are removed from it, and the
+// exported lists the user subscribed to (owned by the user, with the file
+// name as slug) are synced to their collections.
+func (s *syncer) importExport(ctx context.Context, user *config.UserData, zipPath string) error {
+	export, err := lt.ReadExport(zipPath)
+	if err != nil {
+		return err
+	}
+	log.Printf("Importing %d watchlist, %d watched, %d rated, %d diary entries and %d lists of %s", len(export.Watchlist), len(export.Watched), len(export.Ratings), len(export.Diary), len(export.Lists), user.Username)
+
+	userId, err := s.jellyfin.GetUserId(ctx, user.JellyfinUserName)
+	if err != nil {
+		return err
+	}
+
+	targets, err := s.watchlistTargets(ctx, user, userId)
+	if err != nil {
+		return err
+	}
+
+	// Entries missing from any of the targets are added, see
+	// jellyfin.Client.AddMoviesToCollection.
+	var targetTmdbIds []map[string]bool
+	collectionYears := make(map[string]map[int]bool)
+	for _, target := range targets {
+		collectionViews, err := s.jellyfin.GetUserViews(ctx, userId, target)
+		if err != nil {
+			return err
+		}
+		tmdbIds := make(map[string]bool)
+		for _, view := range collectionViews {
+			if tmdbKey := view.TmdbKey(); tmdbKey != "" {
+				tmdbIds[tmdbKey] = true
+			}
+			name := exportEntryKey(view.Name, 0)
+			if collectionYears[name] == nil {
+				collectionYears[name] = make(map[int]bool)
+			}
+			collectionYears[name][view.ProductionYear] = true
+		}
+		targetTmdbIds = append(targetTmdbIds, tmdbIds)
+	}
+
+	watchlist := s.resolveExportEntries(ctx, export.Watchlist, s.scrapper.ResolveUris)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var films []lt.Film
+	for _, film := range watchlist {
+		missing := slices.ContainsFunc(targetTmdbIds, func(tmdbIds map[string]bool) bool {
+			return !tmdbIds[film.TmdbKey()]
+		})
+		if missing {
+			films = append(films, film)
+		}
+	}
+	log.Printf("%d of %d watchlist entries are missing from the collection", len(films), len(watchlist))
+	var errs []error
+	if err := s.addFilms(ctx, films, user.Username, userId, targets); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Resolving every watched film would take hours. Entries in the slug
+	// cache are matched by TMDB id, the others are only resolved when named
+	// like an item of the collection released the same year. Ratings and
+	// diary entries are usually in watched.csv too, the first entry of a
+	// film is kept.
+	watchedTmdbIds := make(map[string]bool)
+	seen := make(map[string]bool)
+	watchedCandidates := func(entries []lt.ExportEntry) []lt.ExportEntry {
+		var candidates []lt.ExportEntry
+		for _, entry := range entries {
+			if film, ok := s.scrapper.CachedUri(entry.Uri); ok {
+				watchedTmdbIds[film.TmdbKey()] = true
+				continue
+			}
+			// Items and entries without a year match the ones of any year.
+			key := exportEntryKey(entry.Name, entry.Year)
+			years := collectionYears[exportEntryKey(entry.Name, 0)]
+			if seen[key] || !(years[entry.Year] || years[0] || entry.Year == 0 && len(years) > 0) {
+				continue
+			}
+			seen[key] = true
+			candidates = append(candidates, entry)
+		}
+		return candidates
+	}
+	for _, film := range s.resolveExportEntries(ctx, watchedCandidates(slices.Concat(export.Watched, export.Ratings)), s.scrapper.ResolveUris) {
+		watchedTmdbIds[film.TmdbKey()] = true
+	}
+	for _, film := range s.resolveExportEntries(ctx, watchedCandidates(export.Diary), s.scrapper.ResolveDiaryUris) {
+		watchedTmdbIds[film.TmdbKey()] = true
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, target := range targets {
+		if _, err := s.jellyfin.RemoveMoviesFromCollection(ctx, userId, target, watchedTmdbIds, "watched on Letterboxd"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name, entries := range export.Lists {
+		index := slices.IndexFunc(user.Lists, func(list config.LetterboxdList) bool {
+			return strings.EqualFold(list.Owner, user.Username) && list.Slug == name
+		})
+		if index < 0 {
+			log.Printf("Skipping exported list %s, %s did not subscribe to it", name, user.Username)
+			continue
+		}
+		if err := s.importExportList(ctx, user.Username, userId, user.Lists[index], entries); err != nil {
+			errs = append(errs, fmt.Errorf("list %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// importExportList adds the exported list entries missing from the collection
+// of the list, in list order.
+func (s *syncer) importExportList(ctx context.Context, requestedBy string, userId string, list config.LetterboxdList, entries []lt.ExportEntry) error {
+	slices.SortStableFunc(entries, func(a, b lt.ExportEntry) int {
+		return a.Position - b.Position
+	})
+
+	target := jf.Collection(list.CollectionId)
+	collectionTmdbIds, err := s.jellyfin.GetCollectionTmdbIds(ctx, userId, target)
+	if err != nil {
+		return err
+	}
+
+	var films []lt.Film
+	for _, film := range s.resolveExportEntries(ctx, entries, s.scrapper.ResolveUris) {
+		if !collectionTmdbIds[film.TmdbKey()] {
+			films = append(films, film)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	log.Printf("%d of %d entries of list %s are missing from the collection", len(films), len(entries), list.Slug)
+
+	var tags []string
+	if list.RadarrTag != "" {
+		tags = append(tags, list.RadarrTag)
+	}
+	return s.addFilms(ctx, films, requestedBy, userId, []jf.Target{target}, tags...)
+}
+
+// exportEntryKey identifies a film by its name, ignoring case and
+// punctuation, and its year.
+func exportEntryKey(name string, year int) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return fmt.Sprintf("%s (%d)", strings.Join(words, " "), year)
+}
+
+// resolveExportEntries resolves the Letterboxd URIs of the entries with
+// resolve, entries that cannot be resolved are logged and skipped.
+func (s *syncer) resolveExportEntries(ctx context.Context, entries []lt.ExportEntry, resolve func(context.Context, []string) ([]lt.Film, []error)) []lt.Film {
+	uris := make([]string, len(entries))
+	for index, entry := range entries {
+		uris[index] = entry.Uri
+	}
+
+	var films []lt.Film
+	resolved, errs := resolve(ctx, uris)
+	for index, film := range resolved {
+		if errs[index] != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to resolve %s (%d) from %s: %v", entries[index].Name, entries[index].Year, entries[index].Uri, errs[index])
+			}
+			continue
+		}
+		films = append(films, film)
+	}
+	return films
+}
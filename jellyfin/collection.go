@@ -0,0 +1,203 @@
+package jellyfin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
+)
+
+// NewCollection describes the collection created by EnsureCollection.
+type NewCollection struct {
+	Name string
+	// Optional, Jellyfin downloads the poster from PosterUrl.
+	Overview  string
+	PosterUrl string
+}
+
+// Response of the creation of a collection or a playlist.
+type creationResult struct {
+	Id string
+}
+
+// getItem returns the item as seen by the user. It is decoded as a map so
+// that SetItemOverview can send it back without dropping the fields it does not
+// know.
+func (jc *Client) getItem(ctx context.Context, userId string, itemId string) (map[string]any, error) {
+	body, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "GET",
+		Url:    jc.Url + "Users/" + userId + "/Items/" + itemId,
+		Body:   nil,
+		Headers: f.Header{
+			"content-type": "application/json; charset=utf-8",
+		},
+		Params: f.Param{
+			"ApiKey": jc.ApiKey,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item %s: %w", itemId, err)
+	}
+
+	var item map[string]any
+	if err := json.Unmarshal(body, &item); err != nil {
+		return nil, fmt.Errorf("%w: item %s: %w", ErrInvalidResponse, itemId, err)
+	}
+	return item, nil
+}
+
+// ItemExists reports whether the item, a collection or a playlist for
+// instance, is still in the library.
+func (jc *Client) ItemExists(ctx context.Context, userId string, itemId string) (bool, error) {
+	_, err := jc.getItem(ctx, userId, itemId)
+
+	var statusErr *f.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateCollection creates an empty collection and returns its id. The
+// collection is locked so that Jellyfin does not change its metadata.
+func (jc *Client) CreateCollection(ctx context.Context, name string) (string, error) {
+	body, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "POST",
+		Url:    jc.Url + "Collections",
+		Body:   nil,
+		Headers: f.Header{
+			"content-type": "application/json; charset=utf-8",
+		},
+		Params: f.Param{
+			"ApiKey":   jc.ApiKey,
+			"Name":     name,
+			"IsLocked": "true",
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create collection %s: %w", name, err)
+	}
+
+	var result creationResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("%w: created collection %s: %w", ErrInvalidResponse, name, err)
+	}
+	if result.Id == "" {
+		return "", fmt.Errorf("%w: created collection %s has no id", ErrInvalidResponse, name)
+	}
+	return result.Id, nil
+}
+
+// SetItemOverview replaces the overview of the item, the other fields are
+// sent back unchanged.
+func (jc *Client) SetItemOverview(ctx context.Context, userId string, itemId string, overview string) error {
+	item, err := jc.getItem(ctx, userId, itemId)
+	if err != nil {
+		return err
+	}
+	item["Overview"] = overview
+
+	_, err = jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "POST",
+		Url:    jc.Url + "Items/" + itemId,
+		Body:   item,
+		Headers: f.Header{
+			"content-type": "application/json; charset=utf-8",
+		},
+		Params: f.Param{
+			"ApiKey": jc.ApiKey,
+		},
+		WantErrCodes: []int{204},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the overview of item %s: %w", itemId, err)
+	}
+	return nil
+}
+
+// SetItemPoster makes Jellyfin download the image as the poster of the item.
+func (jc *Client) SetItemPoster(ctx context.Context, itemId string, imageUrl string) error {
+	_, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "POST",
+		Url:    jc.Url + "Items/" + itemId + "/RemoteImages/Download",
+		Body:   nil,
+		Headers: f.Header{
+			"content-type": "application/json; charset=utf-8",
+		},
+		Params: f.Param{
+			"ApiKey":   jc.ApiKey,
+			"Type":     "Primary",
+			"ImageUrl": imageUrl,
+		},
+		WantErrCodes: []int{204},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the poster of item %s: %w", itemId, err)
+	}
+	return nil
+}
+
+// firstExisting returns the first of the item ids that is still in the
+// library, empty ids being skipped, or an empty id when none is.
+func (jc *Client) firstExisting(ctx context.Context, userId string, itemIds []string) (string, error) {
+	for _, itemId := range itemIds {
+		if itemId == "" {
+			continue
+		}
+		exists, err := jc.ItemExists(ctx, userId, itemId)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return itemId, nil
+		}
+		log.Printf("Item %s is not in the Jellyfin library anymore\n", itemId)
+	}
+	return "", nil
+}
+
+// EnsureCollection returns the first of the collection ids that is still in
+// the library, empty ids being skipped, or creates the collection when none
+// is. created reports whether the collection was created. The overview and
+// the poster are optional, failing to set them only logs the error. In
+// dry-run mode the creation is only planned and the returned id is empty,
+// GetUserViews sees such a collection as empty.
+func (jc *Client) EnsureCollection(ctx context.Context, userId string, collectionIds []string, collection NewCollection) (id string, created bool, err error) {
+	existing, err := jc.firstExisting(ctx, userId, collectionIds)
+	if existing != "" || err != nil {
+		return existing, false, err
+	}
+
+	plan.FromContext(ctx).AddCollectionChange(plan.CollectionChange{
+		Action: plan.ActionCreate,
+		Name:   collection.Name,
+	})
+	collectionId, err := jc.CreateCollection(ctx, collection.Name)
+	if errors.Is(err, f.ErrDryRun) {
+		return "", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	log.Printf("Created collection %s (%s)\n", collection.Name, collectionId)
+
+	if collection.Overview != "" {
+		if err := jc.SetItemOverview(ctx, userId, collectionId, collection.Overview); err != nil {
+			log.Println(err)
+		}
+	}
+	if collection.PosterUrl != "" {
+		if err := jc.SetItemPoster(ctx, collectionId, collection.PosterUrl); err != nil {
+			log.Println(err)
+		}
+	}
+	return collectionId, true, nil
+}
@@ -0,0 +1,91 @@
+package jellyfin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+)
+
+func TestEnsureCollection(t *testing.T) {
+	const itemsUrl = "http://jellyfin.test/Users/user/Items/"
+	notFound := &f.StatusError{StatusCode: 404}
+
+	tests := []struct {
+		name          string
+		collectionIds []string
+		// Responses by url, the missing ones fail the test.
+		responses   map[string]error
+		createErr   error
+		want        string
+		wantCreated bool
+	}{
+		{
+			name:          "Test configured collection exists",
+			collectionIds: []string{"configured", "created"},
+			responses:     map[string]error{itemsUrl + "configured": nil},
+			want:          "configured",
+		},
+		{
+			name:          "Test configured collection deleted",
+			collectionIds: []string{"configured", "created"},
+			responses:     map[string]error{itemsUrl + "configured": notFound, itemsUrl + "created": nil},
+			want:          "created",
+		},
+		{
+			name:          "Test no collection",
+			collectionIds: []string{"", ""},
+			responses: map[string]error{
+				itemsUrl + "new":                                       nil,
+				"http://jellyfin.test/Items/new":                       nil,
+				"http://jellyfin.test/Items/new/RemoteImages/Download": nil,
+			},
+			want:        "new",
+			wantCreated: true,
+		},
+		{
+			name:          "Test dry run",
+			collectionIds: []string{"configured"},
+			responses:     map[string]error{itemsUrl + "configured": notFound},
+			createErr:     f.ErrDryRun,
+			want:          "",
+			wantCreated:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockClient)
+			for url, err := range tt.responses {
+				mockClient.On("FetchData", url).Return([]byte(`{"Id":"item"}`), err)
+			}
+			if tt.wantCreated {
+				mockClient.On("FetchData", "http://jellyfin.test/Collections").Return([]byte(`{"Id":"new"}`), tt.createErr)
+			}
+
+			got, created, err := newTestClient(mockClient).EnsureCollection(context.Background(), "user", tt.collectionIds, NewCollection{
+				Name:      "user's Watchlist",
+				Overview:  "Movies user wants to watch",
+				PosterUrl: "https://example.com/poster.jpg",
+			})
+			if err != nil {
+				t.Fatalf("EnsureCollection() error = %v", err)
+			}
+			if got != tt.want || created != tt.wantCreated {
+				t.Errorf("EnsureCollection() = %s, %v, want %s, %v", got, created, tt.want, tt.wantCreated)
+			}
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUserViewsPlannedCollection(t *testing.T) {
+	mockClient := new(MockClient)
+
+	got, err := newTestClient(mockClient).GetUserViews(context.Background(), "user", Collection(""))
+	if err != nil || got != nil {
+		t.Errorf("GetUserViews() = %v, %v, want an empty collection", got, err)
+	}
+	mockClient.AssertNotCalled(t, "FetchData", mock.Anything)
+}
@@ -1,14 +1,28 @@
 package jellyfin
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"os"
 	"strings"
+	"time"
+	"unicode"
 
+	"diikstra.fr/letterboxd-jellyfin-go/config"
 	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
 	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+	sn "diikstra.fr/letterboxd-jellyfin-go/sonarr"
+)
+
+var (
+	ErrUserNotFound = errors.New("jellyfin user not found")
+	// ErrMovieNotFound is returned when a movie or series is not in the
+	// library yet, usually because Radarr or Sonarr did not download it.
+	ErrMovieNotFound   = errors.New("movie not found in the Jellyfin library")
+	ErrInvalidResponse = errors.New("invalid Jellyfin response")
 )
 
 type User struct {
@@ -16,35 +30,52 @@ type User struct {
 	Id   string
 }
 
-const JellyfinUrl = "https://stream.diikstra.fr/"
+// Client talks to the Jellyfin API of the configured server.
+type Client struct {
+	Fetcher f.FetcherClient
+	Url     string
+	ApiKey  string
+}
 
-func GetUsers(client f.FetcherClient) []User {
-	body, err := client.FetchData(f.FetcherParams{
+func NewClient(fetcher f.FetcherClient, conf *config.Configuration) *Client {
+	return &Client{
+		Fetcher: fetcher,
+		Url:     strings.TrimSuffix(conf.JellyfinUrl, "/") + "/",
+		ApiKey:  conf.JellyfinApiKey,
+	}
+}
+
+func (jc *Client) GetUsers(ctx context.Context) ([]User, error) {
+	body, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
 		Method: "GET",
-		Url:    JellyfinUrl + "Users",
+		Url:    jc.Url + "Users",
 		Body:   nil,
 		Headers: f.Header{
 			"content-type": "application/json; charset=utf-8",
 		},
 		Params: f.Param{
-			"ApiKey": os.Getenv("JELLYFIN_API_KEY"),
+			"ApiKey": jc.ApiKey,
 		},
 		UseProxy: false,
 	})
 
 	if err != nil {
-		log.Printf("Failed to get users from Jellyfin: %v", err)
-		return nil
+		return nil, fmt.Errorf("failed to get users from Jellyfin: %w", err)
 	}
 
 	var users []User
-	json.Unmarshal(body, &users)
+	if err := json.Unmarshal(body, &users); err != nil {
+		return nil, fmt.Errorf("%w: users: %w", ErrInvalidResponse, err)
+	}
 
-	return users
+	return users, nil
 }
 
-func GetUserId(client f.FetcherClient, userName string) (string, error) {
-	users := GetUsers(client)
+func (jc *Client) GetUserId(ctx context.Context, userName string) (string, error) {
+	users, err := jc.GetUsers(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	var userId string
 	for _, user := range users {
@@ -55,7 +86,7 @@ func GetUserId(client f.FetcherClient, userName string) (string, error) {
 	}
 
 	if userId == "" {
-		return "", errors.New("no user matching found")
+		return "", fmt.Errorf("%w: %s", ErrUserNotFound, userName)
 	}
 
 	return userId, nil
@@ -66,136 +97,445 @@ type UserData struct {
 }
 
 type UserView struct {
-	Name     string
-	Id       string
-	UserData UserData
+	Name        string
+	Id          string
+	Type        string
+	UserData    UserData
+	ProviderIds map[string]string
+	// Release year of the item, 0 when Jellyfin does not know it.
+	ProductionYear int `json:",omitempty"`
+	// Entry of the item in a playlist, which may hold an item several times.
+	PlaylistItemId string `json:",omitempty"`
+}
+
+// TmdbKey matches letterboxd.Film.TmdbKey, series ids are prefixed with "tv:".
+// It is empty when the item has no TMDB id.
+func (uv UserView) TmdbKey() string {
+	return tmdbKey(uv.Type, uv.ProviderIds["Tmdb"])
+}
+
+func tmdbKey(itemType string, tmdbId string) string {
+	if tmdbId != "" && itemType == "Series" {
+		return "tv:" + tmdbId
+	}
+	return tmdbId
 }
 
 type ReqUserViewWrapper struct {
 	Items []UserView
 }
 
-func GetUserViews(client f.FetcherClient, userId string, userCollectionId string) ([]UserView, error) {
-	body, err := client.FetchData(f.FetcherParams{
+func (jc *Client) GetUserViews(ctx context.Context, userId string, target Target) ([]UserView, error) {
+	// A collection or playlist only planned by a dry run, without a parent id
+	// the whole library would be returned.
+	if target.Id == "" {
+		return nil, nil
+	}
+
+	url := jc.Url + "Items"
+	params := f.Param{
+		"ApiKey":         jc.ApiKey,
+		"enableUserData": "true",
+		"userId":         userId,
+		"fields":         "ProviderIds",
+	}
+	if target.Playlist {
+		url = jc.Url + "Playlists/" + target.Id + "/Items"
+	} else {
+		params["ParentId"] = target.Id
+		params["Recursive"] = "true"
+		params["IncludeItemTypes"] = "Movie,Series"
+	}
+
+	body, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
 		Method: "GET",
-		Url:    JellyfinUrl + "Items",
+		Url:    url,
 		Body:   nil,
 		Headers: f.Header{
 			"content-type": "application/json; charset=utf-8",
 		},
-		Params: f.Param{
-			"ApiKey":           os.Getenv("JELLYFIN_API_KEY"),
-			"ParentId":         userCollectionId,
-			"Recursive":        "true",
-			"IncludeItemTypes": "Movie",
-			"enableUserData":   "true",
-			"userId":           userId,
-		},
+		Params: params,
 	})
 
 	if err != nil {
-		log.Printf("Failed to get user %s views in collection %s: %v", userId, userCollectionId, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to get user %s views in %s: %w", userId, target, err)
 	}
 
 	var userView ReqUserViewWrapper
-	json.Unmarshal(body, &userView)
+	if err := json.Unmarshal(body, &userView); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrInvalidResponse, target, err)
+	}
 
 	return userView.Items, nil
 }
 
-func RemoveSeenMoviesFromUserCollection(client f.FetcherClient, userId string, userCollectionId string) int {
-	userViews, err := GetUserViews(client, userId, userCollectionId)
+// GetCollectionTmdbIds returns the set of TMDB keys (see UserView.TmdbKey) of
+// the movies and series in the collection or playlist.
+func (jc *Client) GetCollectionTmdbIds(ctx context.Context, userId string, target Target) (map[string]bool, error) {
+	userViews, err := jc.GetUserViews(ctx, userId, target)
+	if err != nil {
+		return nil, err
+	}
+
+	tmdbIds := make(map[string]bool)
+	for _, movie := range userViews {
+		if tmdbId := movie.TmdbKey(); tmdbId != "" {
+			tmdbIds[tmdbId] = true
+		}
+	}
+
+	return tmdbIds, nil
+}
+
+func (jc *Client) removeItemFromCollection(ctx context.Context, target Target, item UserView, reason string) error {
+	plan.FromContext(ctx).AddCollectionChange(plan.CollectionChange{
+		CollectionId: target.Id,
+		Action:       plan.ActionRemove,
+		ItemId:       item.Id,
+		Name:         item.Name,
+		Reason:       reason,
+	})
+
+	params := f.Param{
+		"ApiKey": jc.ApiKey,
+		"ids":    item.Id,
+	}
+	if target.Playlist {
+		params = f.Param{
+			"ApiKey":   jc.ApiKey,
+			"entryIds": item.PlaylistItemId,
+		}
+	}
+	_, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "DELETE",
+		Url:    jc.Url + target.path() + "/Items",
+		Body:   nil,
+		Headers: f.Header{
+			"content-type": "application/json; charset=utf-8",
+		},
+		Params:       params,
+		WantErrCodes: []int{204},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s from %s: %w", item.Name, target, err)
+	}
+
+	return nil
+}
+
+// removedOrDryRun reports whether a removal succeeded or was only planned,
+// other errors are appended to errs.
+func removedOrDryRun(err error, errs *[]error) bool {
+	if err != nil && !errors.Is(err, f.ErrDryRun) {
+		*errs = append(*errs, err)
+		return false
+	}
+	return true
+}
+
+func (jc *Client) RemoveSeenMoviesFromUserCollection(ctx context.Context, userId string, target Target) (int, error) {
+	userViews, err := jc.GetUserViews(ctx, userId, target)
 	numberOfMoviesRemoved := 0
 
 	if err != nil {
-		log.Printf("Failed to get user %s views in collection %s", userId, userCollectionId)
-		return -1
+		return 0, err
 	}
 
+	var errs []error
 	for _, movie := range userViews {
 		if movie.UserData.Played {
-			log.Printf("Deleting %s of user %s from collection %s\n", movie.Name, userId, userCollectionId)
-			client.FetchData(f.FetcherParams{
-				Method: "DELETE",
-				Url:    JellyfinUrl + "Collections/" + userCollectionId + "/Items",
-				Body:   nil,
-				Headers: f.Header{
-					"content-type": "application/json; charset=utf-8",
-				},
-				Params: f.Param{
-					"ApiKey": os.Getenv("JELLYFIN_API_KEY"),
-					"ids":    movie.Id,
-				},
-				WantErrCodes: []int{204},
-			})
+			log.Printf("Deleting %s of user %s from %s\n", movie.Name, userId, target)
+			if removedOrDryRun(jc.removeItemFromCollection(ctx, target, movie, "played"), &errs) {
+				numberOfMoviesRemoved += 1
+			}
+		}
+	}
+
+	return numberOfMoviesRemoved, errors.Join(errs...)
+}
+
+// RemoveMoviesFromCollection removes the items of the collection or playlist
+// whose TMDB key (see UserView.TmdbKey) is in tmdbKeys.
+func (jc *Client) RemoveMoviesFromCollection(ctx context.Context, userId string, target Target, tmdbKeys map[string]bool, reason string) (int, error) {
+	userViews, err := jc.GetUserViews(ctx, userId, target)
+	numberOfMoviesRemoved := 0
+
+	if err != nil {
+		return 0, err
+	}
 
+	var errs []error
+	for _, movie := range userViews {
+		if tmdbKey := movie.TmdbKey(); tmdbKey == "" || !tmdbKeys[tmdbKey] {
+			continue
+		}
+
+		log.Printf("Deleting %s of user %s from %s, %s\n", movie.Name, userId, target, reason)
+		if removedOrDryRun(jc.removeItemFromCollection(ctx, target, movie, reason), &errs) {
 			numberOfMoviesRemoved += 1
 		}
 	}
 
-	return numberOfMoviesRemoved
+	return numberOfMoviesRemoved, errors.Join(errs...)
+}
+
+// RemoveUnlistedMoviesFromCollection removes the movies of the collection or
+// playlist that are not in the watchlist anymore. A movie is only removed once it has
+// been missing for the grace period: missingSince records when each movie was
+// first found missing and is updated in place. Both are keyed by TMDB key (see
+// UserView.TmdbKey). Movies without a TMDB id are never removed.
+func (jc *Client) RemoveUnlistedMoviesFromCollection(ctx context.Context, userId string, target Target, watchlistTmdbIds map[string]bool, missingSince map[string]time.Time, gracePeriod time.Duration) (int, error) {
+	userViews, err := jc.GetUserViews(ctx, userId, target)
+	numberOfMoviesRemoved := 0
+
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	stillMissing := make(map[string]bool)
+	for _, movie := range userViews {
+		tmdbId := movie.TmdbKey()
+		if tmdbId == "" || watchlistTmdbIds[tmdbId] {
+			continue
+		}
+
+		since, ok := missingSince[tmdbId]
+		if !ok {
+			since = time.Now()
+			missingSince[tmdbId] = since
+		}
+		if time.Since(since) < gracePeriod {
+			stillMissing[tmdbId] = true
+			continue
+		}
+
+		log.Printf("Deleting %s of user %s from %s, not in the watchlist since %s\n", movie.Name, userId, target, since.Format(time.RFC3339))
+		if !removedOrDryRun(jc.removeItemFromCollection(ctx, target, movie, "not in watchlist"), &errs) {
+			stillMissing[tmdbId] = true
+			continue
+		}
+		numberOfMoviesRemoved += 1
+	}
+
+	// Forget movies that came back to the watchlist or left the collection.
+	for tmdbId := range missingSince {
+		if !stillMissing[tmdbId] {
+			delete(missingSince, tmdbId)
+		}
+	}
+
+	return numberOfMoviesRemoved, errors.Join(errs...)
 }
 
+// MoviesItem is a movie or series of the Jellyfin library, Type is "Movie" or
+// "Series".
 type MoviesItem struct {
 	Name           string
 	ProductionYear int
 	Id             string
+	Type           string
+	ProviderIds    map[string]string
 }
 
 type Movies struct {
 	Items []MoviesItem
 }
 
-func GetAllMovies(client f.FetcherClient) *[]MoviesItem {
-	body, err := client.FetchData(f.FetcherParams{
+func (jc *Client) GetAllMovies(ctx context.Context) (*[]MoviesItem, error) {
+	body, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
 		Method: "GET",
-		Url:    JellyfinUrl + "Items",
+		Url:    jc.Url + "Items",
 		Body:   nil,
 		Headers: f.Header{
 			"content-type": "application/json; charset=utf-8",
 		},
 		Params: f.Param{
-			"ApiKey":           os.Getenv("JELLYFIN_API_KEY"),
+			"ApiKey":           jc.ApiKey,
 			"Recursive":        "true",
-			"IncludeItemTypes": "Movie",
-			"fields":           "MediaSources,People",
+			"IncludeItemTypes": "Movie,Series",
+			"fields":           "MediaSources,People,ProviderIds",
 		},
 		UseProxy: false,
 	})
 
 	if err != nil {
-		log.Printf("Failed to get all movies from Jellyfin: %v", err)
-		return nil
+		return nil, fmt.Errorf("failed to get all movies from Jellyfin: %w", err)
 	}
 
 	var res Movies
-	json.Unmarshal(body, &res)
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("%w: library: %w", ErrInvalidResponse, err)
+	}
+
+	return &res.Items, nil
+}
 
-	return &res.Items
+// MovieIndex resolves Radarr movies and Sonarr series to Jellyfin items,
+// primarily through the TMDB, IMDb and TVDB provider ids Jellyfin stores on
+// each item. TMDB ids are indexed by TMDB key since movie and TV ids overlap.
+type MovieIndex struct {
+	byTmdb           map[string]string
+	byImdb           map[string]string
+	byTvdb           map[string]string
+	withoutIds       []MoviesItem
+	seriesWithoutIds []MoviesItem
+}
+
+func NewMovieIndex(movies *[]MoviesItem) *MovieIndex {
+	index := &MovieIndex{
+		byTmdb: make(map[string]string),
+		byImdb: make(map[string]string),
+		byTvdb: make(map[string]string),
+	}
+	if movies == nil {
+		return index
+	}
+
+	for _, movie := range *movies {
+		tmdbId := movie.ProviderIds["Tmdb"]
+		imdbId := movie.ProviderIds["Imdb"]
+		tvdbId := movie.ProviderIds["Tvdb"]
+		if tmdbId != "" {
+			index.byTmdb[tmdbKey(movie.Type, tmdbId)] = movie.Id
+		}
+		if imdbId != "" {
+			index.byImdb[imdbId] = movie.Id
+		}
+		if movie.Type == "Series" {
+			if tvdbId != "" {
+				index.byTvdb[tvdbId] = movie.Id
+			}
+			if tmdbId == "" && imdbId == "" && tvdbId == "" {
+				index.seriesWithoutIds = append(index.seriesWithoutIds, movie)
+			}
+			continue
+		}
+		if tmdbId == "" && imdbId == "" {
+			index.withoutIds = append(index.withoutIds, movie)
+		}
+	}
+
+	return index
+}
+
+// Resolve returns the Jellyfin id of the movie. The title and year are only
+// used against library items that carry no provider id at all.
+func (mi *MovieIndex) Resolve(state rd.RadarrStatus) (string, error) {
+	if id, ok := mi.byTmdb[state.TmdbId]; ok && state.TmdbId != "" {
+		return id, nil
+	}
+	if id, ok := mi.byImdb[state.ImdbId]; ok && state.ImdbId != "" {
+		return id, nil
+	}
+
+	return GetMovieJellyfinId(&mi.withoutIds, state.Title, state.ProductionYear)
+}
+
+// ResolveSeries returns the Jellyfin id of the series, see Resolve.
+func (mi *MovieIndex) ResolveSeries(state sn.SonarrStatus) (string, error) {
+	if id, ok := mi.byTmdb["tv:"+state.TmdbId]; ok && state.TmdbId != "" {
+		return id, nil
+	}
+	if id, ok := mi.byTvdb[state.TvdbId]; ok && state.TvdbId != "" && state.TvdbId != "0" {
+		return id, nil
+	}
+	if id, ok := mi.byImdb[state.ImdbId]; ok && state.ImdbId != "" {
+		return id, nil
+	}
+
+	return GetMovieJellyfinId(&mi.seriesWithoutIds, state.Title, state.ProductionYear)
+}
+
+// Lowercase the title and keep only letters and digits so that punctuation
+// and spacing differences do not prevent a match.
+func normalizeTitle(title string) string {
+	var builder strings.Builder
+	for _, r := range strings.ToLower(strings.ReplaceAll(title, "&", "and")) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
 }
 
 func GetMovieJellyfinId(movies *[]MoviesItem, movie_name string, movie_year int) (string, error) {
+	normalizedName := normalizeTitle(movie_name)
 	for _, movie := range *movies {
-		if movie.Name == movie_name && movie.ProductionYear == movie_year {
+		yearDiff := movie.ProductionYear - movie_year
+		if normalizeTitle(movie.Name) == normalizedName && yearDiff >= -1 && yearDiff <= 1 {
 			return movie.Id, nil
 		}
 	}
 
-	return "", errors.New("unable to find movie in the Jellyfin library")
+	return "", fmt.Errorf("%w: %s (%d)", ErrMovieNotFound, movie_name, movie_year)
 }
 
-func AddMoviesToCollection(client f.FetcherClient, allMovies *[]MoviesItem, radarrStates []rd.RadarrStatus, userId string, userCollectionId string) {
-	const batchSize = 20
+// AddMoviesToCollection adds the movies found in the library to the
+// collection or playlist, the ones not downloaded yet are skipped. A playlist
+// may hold an item several times, the items it already has are skipped too.
+func (jc *Client) AddMoviesToCollection(ctx context.Context, allMovies *[]MoviesItem, radarrStates []rd.RadarrStatus, userId string, target Target) error {
+	present, err := jc.playlistItemIds(ctx, userId, target)
+	if err != nil {
+		return err
+	}
 	var ids []string
 
+	index := NewMovieIndex(allMovies)
 	for _, state := range radarrStates {
-		jellyfinId, err := GetMovieJellyfinId(allMovies, state.Title, state.ProductionYear)
-		if err == nil {
-			ids = append(ids, jellyfinId)
+		jellyfinId, err := index.Resolve(state)
+		if err != nil {
+			log.Printf("Unable to resolve %s (%d, tmdb:%s) in the Jellyfin library\n", state.Title, state.ProductionYear, state.TmdbId)
+			continue
+		}
+		if present[jellyfinId] {
+			continue
+		}
+		ids = append(ids, jellyfinId)
+		plan.FromContext(ctx).AddCollectionChange(plan.CollectionChange{
+			CollectionId: target.Id,
+			Action:       plan.ActionAdd,
+			ItemId:       jellyfinId,
+			Name:         state.Title,
+		})
+	}
+
+	return jc.addItemsToCollection(ctx, userId, target, ids)
+}
+
+func (jc *Client) AddSeriesToCollection(ctx context.Context, allMovies *[]MoviesItem, sonarrStates []sn.SonarrStatus, userId string, target Target) error {
+	present, err := jc.playlistItemIds(ctx, userId, target)
+	if err != nil {
+		return err
+	}
+	var ids []string
+
+	index := NewMovieIndex(allMovies)
+	for _, state := range sonarrStates {
+		jellyfinId, err := index.ResolveSeries(state)
+		if err != nil {
+			log.Printf("Unable to resolve series %s (%d, tmdb:%s) in the Jellyfin library\n", state.Title, state.ProductionYear, state.TmdbId)
+			continue
+		}
+		if present[jellyfinId] {
+			continue
 		}
+		ids = append(ids, jellyfinId)
+		plan.FromContext(ctx).AddCollectionChange(plan.CollectionChange{
+			CollectionId: target.Id,
+			Action:       plan.ActionAdd,
+			ItemId:       jellyfinId,
+			Name:         state.Title,
+		})
 	}
 
+	return jc.addItemsToCollection(ctx, userId, target, ids)
+}
+
+func (jc *Client) addItemsToCollection(ctx context.Context, userId string, target Target, ids []string) error {
+	const batchSize = 20
+
+	var errs []error
 	for i := 0; i < len(ids); i += batchSize {
 		end := i + batchSize
 		if end > len(ids) {
@@ -203,20 +543,29 @@ func AddMoviesToCollection(client f.FetcherClient, allMovies *[]MoviesItem, rada
 		}
 
 		batch := ids[i:end]
-		client.FetchData(f.FetcherParams{
+		params := f.Param{
+			"ApiKey": jc.ApiKey,
+			"ids":    joinIds(batch),
+		}
+		if target.Playlist {
+			params["userId"] = userId
+		}
+		_, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
 			Method: "POST",
-			Url:    JellyfinUrl + "Collections/" + userCollectionId + "/Items",
+			Url:    jc.Url + target.path() + "/Items",
 			Body:   nil,
 			Headers: f.Header{
 				"content-type": "application/json; charset=utf-8",
 			},
-			Params: f.Param{
-				"ApiKey": os.Getenv("JELLYFIN_API_KEY"),
-				"ids":    joinIds(batch),
-			},
+			Params:       params,
 			WantErrCodes: []int{204},
 		})
+		if err != nil && !errors.Is(err, f.ErrDryRun) {
+			errs = append(errs, fmt.Errorf("failed to add %d items to %s: %w", len(batch), target, err))
+		}
 	}
+
+	return errors.Join(errs...)
 }
 
 func joinIds(ids []string) string {
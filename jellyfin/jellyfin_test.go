@@ -1,15 +1,21 @@
 package jellyfin
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/mock"
 
 	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+	sn "diikstra.fr/letterboxd-jellyfin-go/sonarr"
 )
 
 type MockClient struct {
@@ -21,6 +27,18 @@ func (m *MockClient) FetchData(fp f.FetcherParams) ([]byte, error) {
 	return args.Get(0).([]byte), args.Error(1)
 }
 
+func (m *MockClient) FetchDataContext(ctx context.Context, fp f.FetcherParams) ([]byte, error) {
+	return m.FetchData(fp)
+}
+
+func newTestClient(mockClient *MockClient) *Client {
+	return &Client{
+		Fetcher: mockClient,
+		Url:     "http://jellyfin.test/",
+		ApiKey:  "test",
+	}
+}
+
 // Get info of the current directory of the executed file
 var (
 	_, b, _, _ = runtime.Caller(0)
@@ -80,11 +98,14 @@ func TestGetUserId(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockClient := new(MockClient)
 			mockClient.On("FetchData", mock.Anything).Return(tt.clientResponse, nil)
-			got, err := GetUserId(mockClient, tt.args.userName)
+			got, err := newTestClient(mockClient).GetUserId(context.Background(), tt.args.userName)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUserId() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr && !errors.Is(err, ErrUserNotFound) {
+				t.Errorf("GetUserId() error = %v, want ErrUserNotFound", err)
+			}
 			if got != tt.want {
 				t.Errorf("GetUserId() = %v, want %v", got, tt.want)
 			}
@@ -138,13 +159,13 @@ func TestGetUserViews(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockClient := new(MockClient)
 			mockClient.On("FetchData", mock.Anything).Return(tt.clientResponse, nil)
-			got, err := GetUserViews(mockClient, tt.args.userId, tt.args.userCollectionId)
+			got, err := newTestClient(mockClient).GetUserViews(context.Background(), tt.args.userId, Collection(tt.args.userCollectionId))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUserViews() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			for index, gotItem := range got {
-				if gotItem != tt.want[index] {
+				if !reflect.DeepEqual(gotItem, tt.want[index]) {
 					t.Errorf("GetUserViews() error = %v, want %v", got, tt.want)
 				}
 			}
@@ -195,9 +216,180 @@ func Test_removeSeenMoviesFromUserCollection(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockClient.On("FetchData", mock.Anything).Return(tt.clientResponse, nil)
-			if got := RemoveSeenMoviesFromUserCollection(mockClient, tt.args.userId, tt.args.userCollectionId); got != tt.want {
+			got, err := newTestClient(mockClient).RemoveSeenMoviesFromUserCollection(context.Background(), tt.args.userId, Collection(tt.args.userCollectionId))
+			if err != nil {
+				t.Errorf("removeSeenMoviesFromUserCollection() returned error: %v", err)
+			}
+			if got != tt.want {
 				t.Errorf("removeSeenMoviesFromUserCollection() error = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestMovieIndexResolve(t *testing.T) {
+	movies := []MoviesItem{{
+		Name:           "Le Fabuleux Destin d'Amélie Poulain",
+		ProductionYear: 2001,
+		Id:             "amelie",
+		ProviderIds:    map[string]string{"Tmdb": "194", "Imdb": "tt0211915"},
+	}, {
+		Name:           "Alien",
+		ProductionYear: 1979,
+		Id:             "alien",
+		ProviderIds:    map[string]string{"Imdb": "tt0078748"},
+	}, {
+		Name:           "Spider-Man: Across the Spider-Verse",
+		ProductionYear: 2023,
+		Id:             "spiderverse",
+	}}
+
+	tests := []struct {
+		name    string
+		state   rd.RadarrStatus
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Test localized title matched by TMDB id",
+			state: rd.RadarrStatus{Title: "Amélie", ProductionYear: 2001, TmdbId: "194"},
+			want:  "amelie",
+		},
+		{
+			name:  "Test matched by IMDb id",
+			state: rd.RadarrStatus{Title: "Alien", ProductionYear: 1979, TmdbId: "348", ImdbId: "tt0078748"},
+			want:  "alien",
+		},
+		{
+			name:  "Test fuzzy title and year fallback",
+			state: rd.RadarrStatus{Title: "Spider-Man Across The Spider Verse", ProductionYear: 2022, TmdbId: "569094"},
+			want:  "spiderverse",
+		},
+		{
+			name:    "Test title match ignored when item has provider ids",
+			state:   rd.RadarrStatus{Title: "Alien", ProductionYear: 1979, TmdbId: "999"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMovieIndex(&movies).Resolve(tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMovieIndexResolveSeries(t *testing.T) {
+	movies := []MoviesItem{{
+		Name:           "Le Fabuleux Destin d'Amélie Poulain",
+		ProductionYear: 2001,
+		Id:             "amelie",
+		Type:           "Movie",
+		ProviderIds:    map[string]string{"Tmdb": "194"},
+	}, {
+		Name:           "Chernobyl",
+		ProductionYear: 2019,
+		Id:             "chernobyl",
+		Type:           "Series",
+		ProviderIds:    map[string]string{"Tvdb": "360893"},
+	}, {
+		Name:           "Band of Brothers",
+		ProductionYear: 2001,
+		Id:             "band-of-brothers",
+		Type:           "Series",
+		ProviderIds:    map[string]string{"Tmdb": "4613"},
+	}, {
+		Name:           "Alien",
+		ProductionYear: 1979,
+		Id:             "alien",
+		Type:           "Movie",
+	}}
+
+	tests := []struct {
+		name    string
+		state   sn.SonarrStatus
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Test matched by TMDB id",
+			state: sn.SonarrStatus{Title: "Band of Brothers", ProductionYear: 2001, TmdbId: "4613"},
+			want:  "band-of-brothers",
+		},
+		{
+			name:  "Test matched by TVDB id",
+			state: sn.SonarrStatus{Title: "Chernobyl", ProductionYear: 2019, TmdbId: "87108", TvdbId: "360893"},
+			want:  "chernobyl",
+		},
+		{
+			name:    "Test movie TMDB id does not match a series",
+			state:   sn.SonarrStatus{Title: "Dekalog", ProductionYear: 1989, TmdbId: "194"},
+			wantErr: true,
+		},
+		{
+			name:    "Test title fallback ignores movies",
+			state:   sn.SonarrStatus{Title: "Alien", ProductionYear: 1979, TmdbId: "1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMovieIndex(&movies).ResolveSeries(tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveSeries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ResolveSeries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveUnlistedMoviesFromCollection(t *testing.T) {
+	initTestEnvironnement(t)
+
+	byteTestData_1, _ := json.Marshal(ReqUserViewWrapper{
+		Items: []UserView{{
+			Name:        "Still listed",
+			Id:          "listed",
+			ProviderIds: map[string]string{"Tmdb": "1"},
+		}, {
+			Name:        "Recently unlisted",
+			Id:          "recent",
+			ProviderIds: map[string]string{"Tmdb": "2"},
+		}, {
+			Name:        "Unlisted for long",
+			Id:          "old",
+			ProviderIds: map[string]string{"Tmdb": "3"},
+		}, {
+			Name: "Without provider id",
+			Id:   "unknown",
+		}},
+	})
+
+	mockClient := new(MockClient)
+	mockClient.On("FetchData", mock.Anything).Return(byteTestData_1, nil)
+
+	missingSince := map[string]time.Time{
+		"3": time.Now().Add(-100 * time.Hour),
+		"4": time.Now().Add(-100 * time.Hour),
+	}
+	got, err := newTestClient(mockClient).RemoveUnlistedMoviesFromCollection(context.Background(), "exampleUserId", Collection("exampleUserCollectionId"), map[string]bool{"1": true}, missingSince, 72*time.Hour)
+	if err != nil {
+		t.Errorf("RemoveUnlistedMoviesFromCollection() returned error: %v", err)
+	}
+
+	if got != 1 {
+		t.Errorf("RemoveUnlistedMoviesFromCollection() = %v, want %v", got, 1)
+	}
+	if _, ok := missingSince["2"]; !ok || len(missingSince) != 1 {
+		t.Errorf("RemoveUnlistedMoviesFromCollection() left missingSince = %v, want only the recently unlisted movie", missingSince)
+	}
+}
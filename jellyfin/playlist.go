@@ -0,0 +1,120 @@
+package jellyfin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
+)
+
+// Target is a collection, visible to every user of the library, or a private
+// playlist of a user, which the watchlists and lists are synced to.
+type Target struct {
+	Id       string
+	Playlist bool
+}
+
+func Collection(collectionId string) Target {
+	return Target{Id: collectionId}
+}
+
+func Playlist(playlistId string) Target {
+	return Target{Id: playlistId, Playlist: true}
+}
+
+func (t Target) String() string {
+	if t.Playlist {
+		return "playlist " + t.Id
+	}
+	return "collection " + t.Id
+}
+
+// path is the API path of the target, relative to the server url.
+func (t Target) path() string {
+	if t.Playlist {
+		return "Playlists/" + t.Id
+	}
+	return "Collections/" + t.Id
+}
+
+// playlistItemIds returns the set of the Jellyfin ids of the items of the
+// playlist, nil for a collection as adding an item to a collection twice
+// does not duplicate it.
+func (jc *Client) playlistItemIds(ctx context.Context, userId string, target Target) (map[string]bool, error) {
+	if !target.Playlist {
+		return nil, nil
+	}
+
+	userViews, err := jc.GetUserViews(ctx, userId, target)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, item := range userViews {
+		ids[item.Id] = true
+	}
+	return ids, nil
+}
+
+// CreatePlaylist creates an empty private video playlist owned by the user
+// and returns its id.
+func (jc *Client) CreatePlaylist(ctx context.Context, userId string, name string) (string, error) {
+	body, err := jc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "POST",
+		Url:    jc.Url + "Playlists",
+		Body: map[string]any{
+			"Name":      name,
+			"UserId":    userId,
+			"MediaType": "Video",
+			"IsPublic":  false,
+		},
+		Headers: f.Header{
+			"content-type": "application/json; charset=utf-8",
+		},
+		Params: f.Param{
+			"ApiKey": jc.ApiKey,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create playlist %s: %w", name, err)
+	}
+
+	var result creationResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("%w: created playlist %s: %w", ErrInvalidResponse, name, err)
+	}
+	if result.Id == "" {
+		return "", fmt.Errorf("%w: created playlist %s has no id", ErrInvalidResponse, name)
+	}
+	return result.Id, nil
+}
+
+// EnsurePlaylist returns the playlist when it is still in the library, or
+// creates a private playlist for the user. created reports whether the
+// playlist was created. In dry-run mode the creation is only planned and the
+// returned id is empty, see EnsureCollection.
+func (jc *Client) EnsurePlaylist(ctx context.Context, userId string, playlistId string, name string) (id string, created bool, err error) {
+	existing, err := jc.firstExisting(ctx, userId, []string{playlistId})
+	if existing != "" || err != nil {
+		return existing, false, err
+	}
+
+	plan.FromContext(ctx).AddCollectionChange(plan.CollectionChange{
+		Action: plan.ActionCreate,
+		Name:   name,
+		Reason: "private playlist",
+	})
+	playlistId, err = jc.CreatePlaylist(ctx, userId, name)
+	if errors.Is(err, f.ErrDryRun) {
+		return "", true, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	log.Printf("Created playlist %s (%s) of user %s\n", name, playlistId, userId)
+	return playlistId, true, nil
+}
@@ -0,0 +1,97 @@
+package jellyfin
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+)
+
+// recordingClient answers every GET with body and records the other requests.
+type recordingClient struct {
+	body     []byte
+	requests []f.FetcherParams
+}
+
+func (r *recordingClient) FetchData(fp f.FetcherParams) ([]byte, error) {
+	if fp.Method == "GET" {
+		return r.body, nil
+	}
+	r.requests = append(r.requests, fp)
+	return nil, nil
+}
+
+func (r *recordingClient) FetchDataContext(ctx context.Context, fp f.FetcherParams) ([]byte, error) {
+	return r.FetchData(fp)
+}
+
+func TestPlaylistReconciliation(t *testing.T) {
+	body, _ := json.Marshal(ReqUserViewWrapper{
+		Items: []UserView{{
+			Name:           "Played",
+			Id:             "played",
+			UserData:       UserData{Played: true},
+			ProviderIds:    map[string]string{"Tmdb": "1"},
+			PlaylistItemId: "entry1",
+		}, {
+			Name:           "Not played",
+			Id:             "unplayed",
+			ProviderIds:    map[string]string{"Tmdb": "2"},
+			PlaylistItemId: "entry2",
+		}},
+	})
+	allMovies := []MoviesItem{
+		{Name: "Played", Id: "played", ProviderIds: map[string]string{"Tmdb": "1"}},
+		{Name: "New", Id: "new", ProviderIds: map[string]string{"Tmdb": "3"}},
+	}
+	radarrStates := []rd.RadarrStatus{{TmdbId: "1", Title: "Played"}, {TmdbId: "3", Title: "New"}}
+
+	tests := []struct {
+		name string
+		run  func(jc *Client) error
+		want f.FetcherParams
+	}{
+		{
+			name: "Test add skips the items of the playlist",
+			run: func(jc *Client) error {
+				return jc.AddMoviesToCollection(context.Background(), &allMovies, radarrStates, "user", Playlist("playlist"))
+			},
+			want: f.FetcherParams{
+				Method: "POST",
+				Url:    "http://jellyfin.test/Playlists/playlist/Items",
+				Params: f.Param{"ApiKey": "test", "ids": "new", "userId": "user"},
+			},
+		},
+		{
+			name: "Test remove seen uses the playlist entry",
+			run: func(jc *Client) error {
+				_, err := jc.RemoveSeenMoviesFromUserCollection(context.Background(), "user", Playlist("playlist"))
+				return err
+			},
+			want: f.FetcherParams{
+				Method: "DELETE",
+				Url:    "http://jellyfin.test/Playlists/playlist/Items",
+				Params: f.Param{"ApiKey": "test", "entryIds": "entry1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{body: body}
+			jc := &Client{Fetcher: client, Url: "http://jellyfin.test/", ApiKey: "test"}
+			if err := tt.run(jc); err != nil {
+				t.Fatalf("returned error: %v", err)
+			}
+			if len(client.requests) != 1 {
+				t.Fatalf("sent %d requests, want 1", len(client.requests))
+			}
+			got := client.requests[0]
+			if got.Method != tt.want.Method || got.Url != tt.want.Url || !reflect.DeepEqual(got.Params, tt.want.Params) {
+				t.Errorf("sent %s %s %v, want %s %s %v", got.Method, got.Url, got.Params, tt.want.Method, tt.want.Url, tt.want.Params)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package jellyfin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidWebhookEvent = errors.New("invalid Jellyfin webhook event")
+
+// Notification types of the Jellyfin Webhook plugin telling that a user
+// watched an item.
+const (
+	WebhookPlaybackStop  = "PlaybackStop"
+	WebhookUserDataSaved = "UserDataSaved"
+)
+
+// WebhookEvent is a notification of the Jellyfin Webhook plugin, sent with a
+// template rendering these fields as JSON.
+type WebhookEvent struct {
+	NotificationType     string
+	ItemId               string
+	ItemType             string
+	Name                 string
+	NotificationUsername string
+	// Set by PlaybackStop notifications.
+	PlayedToCompletion webhookBool
+	// Set by UserDataSaved notifications.
+	Played webhookBool
+}
+
+// webhookBool accepts JSON booleans and the strings the Handlebars templates
+// of the plugin render them as ("True", "false"...). An empty string is false.
+type webhookBool bool
+
+func (b *webhookBool) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	if value == "" || value == "null" {
+		*b = false
+		return nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	*b = webhookBool(parsed)
+	return nil
+}
+
+func ParseWebhookEvent(body []byte) (WebhookEvent, error) {
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return WebhookEvent{}, fmt.Errorf("%w: %w", ErrInvalidWebhookEvent, err)
+	}
+	return event, nil
+}
+
+// IsFinishedMovie reports whether the event tells that the user finished a
+// movie, either by playing it to the end or by marking it as played.
+func (e WebhookEvent) IsFinishedMovie() bool {
+	if e.ItemType != "Movie" || e.ItemId == "" {
+		return false
+	}
+	switch e.NotificationType {
+	case WebhookPlaybackStop:
+		return bool(e.PlayedToCompletion)
+	case WebhookUserDataSaved:
+		return bool(e.Played)
+	}
+	return false
+}
+
+// sameId compares Jellyfin ids, which are written with or without dashes
+// depending on the API.
+func sameId(a string, b string) bool {
+	return strings.EqualFold(strings.ReplaceAll(a, "-", ""), strings.ReplaceAll(b, "-", ""))
+}
+
+// RemoveItemFromCollection removes the item from the collection or playlist
+// and returns it, nil is returned when the item is not in it.
+func (jc *Client) RemoveItemFromCollection(ctx context.Context, userId string, target Target, itemId string, reason string) (*UserView, error) {
+	userViews, err := jc.GetUserViews(ctx, userId, target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range userViews {
+		if !sameId(item.Id, itemId) {
+			continue
+		}
+		log.Printf("Deleting %s of user %s from %s, %s\n", item.Name, userId, target, reason)
+		if err := jc.removeItemFromCollection(ctx, target, item, reason); err != nil {
+			return nil, err
+		}
+		return &item, nil
+	}
+	return nil, nil
+}
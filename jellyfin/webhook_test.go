@@ -0,0 +1,96 @@
+package jellyfin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseWebhookEvent(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantFinished bool
+		wantErr      bool
+	}{
+		{
+			name:         "Test movie played to completion",
+			body:         `{"NotificationType":"PlaybackStop","ItemId":"abc","ItemType":"Movie","PlayedToCompletion":true}`,
+			wantFinished: true,
+		},
+		{
+			name:         "Test movie stopped halfway",
+			body:         `{"NotificationType":"PlaybackStop","ItemId":"abc","ItemType":"Movie","PlayedToCompletion":"False"}`,
+			wantFinished: false,
+		},
+		{
+			name:         "Test movie marked as played",
+			body:         `{"NotificationType":"UserDataSaved","ItemId":"abc","ItemType":"Movie","Played":"True"}`,
+			wantFinished: true,
+		},
+		{
+			name:         "Test episode played to completion",
+			body:         `{"NotificationType":"PlaybackStop","ItemId":"abc","ItemType":"Episode","PlayedToCompletion":"True"}`,
+			wantFinished: false,
+		},
+		{
+			name:         "Test other notification",
+			body:         `{"NotificationType":"ItemAdded","ItemId":"abc","ItemType":"Movie","PlayedToCompletion":""}`,
+			wantFinished: false,
+		},
+		{
+			name:    "Test invalid boolean",
+			body:    `{"NotificationType":"PlaybackStop","ItemId":"abc","ItemType":"Movie","PlayedToCompletion":"maybe"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := ParseWebhookEvent([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWebhookEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := event.IsFinishedMovie(); got != tt.wantFinished {
+				t.Errorf("IsFinishedMovie() = %v, want %v", got, tt.wantFinished)
+			}
+		})
+	}
+}
+
+func TestRemoveItemFromCollection(t *testing.T) {
+	body, _ := json.Marshal(ReqUserViewWrapper{
+		Items: []UserView{{
+			Name:           "Watched",
+			Id:             "0123456789abcdef0123456789abcdef",
+			ProviderIds:    map[string]string{"Tmdb": "1"},
+			PlaylistItemId: "entry1",
+		}},
+	})
+
+	tests := []struct {
+		name         string
+		itemId       string
+		wantRemoved  bool
+		wantRequests int
+	}{
+		{name: "Test item with dashes", itemId: "01234567-89ab-cdef-0123-456789abcdef", wantRemoved: true, wantRequests: 1},
+		{name: "Test item not in the playlist", itemId: "fedcba9876543210fedcba9876543210", wantRemoved: false, wantRequests: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &recordingClient{body: body}
+			jc := &Client{Fetcher: client, Url: "http://jellyfin.test/", ApiKey: "test"}
+
+			got, err := jc.RemoveItemFromCollection(context.Background(), "user", Playlist("playlist"), tt.itemId, "played")
+			if err != nil {
+				t.Fatalf("RemoveItemFromCollection() error = %v", err)
+			}
+			if (got != nil) != tt.wantRemoved {
+				t.Errorf("RemoveItemFromCollection() = %v, want removed %v", got, tt.wantRemoved)
+			}
+			if len(client.requests) != tt.wantRequests {
+				t.Errorf("sent %d requests, want %d", len(client.requests), tt.wantRequests)
+			}
+		})
+	}
+}
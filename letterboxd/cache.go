@@ -0,0 +1,135 @@
+package letterboxd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Film holds the ids resolved from a Letterboxd film page.
+type Film struct {
+	Slug      string
+	TmdbId    string
+	ImdbId    string
+	MediaType string
+	FetchedAt time.Time
+}
+
+// IsSeries reports whether the Letterboxd entry is a TV series or mini-series.
+func (film Film) IsSeries() bool {
+	return film.MediaType == "tv"
+}
+
+// TmdbKey identifies the film across media types, TMDB movie and TV ids
+// overlap so series ids are prefixed with "tv:".
+func (film Film) TmdbKey() string {
+	if film.IsSeries() {
+		return "tv:" + film.TmdbId
+	}
+	return film.TmdbId
+}
+
+// SlugCache is a persistent slug -> Film cache, saved as a JSON file. Entries
+// older than the TTL are revalidated against Letterboxd before being used.
+type SlugCache struct {
+	path    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]Film
+	dirty   bool
+}
+
+// LoadSlugCache reads the cache file at path. A missing file gives an empty
+// cache, a TTL of 0 means entries never expire.
+func LoadSlugCache(path string, ttl time.Duration) (*SlugCache, error) {
+	cache := &SlugCache{
+		path:    path,
+		ttl:     ttl,
+		entries: make(map[string]Film),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Get returns the cached film and whether it is still fresh. A stale entry is
+// still returned so that it can be used if revalidation fails.
+func (c *SlugCache) Get(slug string) (Film, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	film, ok := c.entries[slug]
+	if !ok {
+		return Film{}, false, false
+	}
+	fresh := c.ttl <= 0 || time.Since(film.FetchedAt) < c.ttl
+	return film, true, fresh
+}
+
+func (c *SlugCache) Put(film Film) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if film.FetchedAt.IsZero() {
+		film.FetchedAt = time.Now()
+	}
+	c.entries[film.Slug] = film
+	c.dirty = true
+}
+
+func (c *SlugCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// Save writes the cache to disk if it changed since it was loaded. The file
+// is replaced atomically so that an interrupted run never corrupts it.
+func (c *SlugCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
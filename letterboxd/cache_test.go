@@ -0,0 +1,47 @@
+package letterboxd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSlugCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slug_cache.json")
+
+	cache, err := LoadSlugCache(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("LoadSlugCache() on missing file returned error: %v", err)
+	}
+
+	cache.Put(Film{Slug: "film/alien/", TmdbId: "348", ImdbId: "tt0078748", MediaType: "movie"})
+	cache.Put(Film{Slug: "film/aliens/", TmdbId: "679", MediaType: "movie", FetchedAt: time.Now().Add(-48 * time.Hour)})
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() returned error: %v", err)
+	}
+
+	reloaded, err := LoadSlugCache(path, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("LoadSlugCache() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		slug      string
+		wantTmdb  string
+		wantFound bool
+		wantFresh bool
+	}{
+		{name: "Test fresh entry", slug: "film/alien/", wantTmdb: "348", wantFound: true, wantFresh: true},
+		{name: "Test expired entry", slug: "film/aliens/", wantTmdb: "679", wantFound: true, wantFresh: false},
+		{name: "Test unknown entry", slug: "film/alien-3/", wantFound: false, wantFresh: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			film, found, fresh := reloaded.Get(tt.slug)
+			if found != tt.wantFound || fresh != tt.wantFresh || film.TmdbId != tt.wantTmdb {
+				t.Errorf("Get(%q) = %v, %v, %v, want tmdb %q, %v, %v", tt.slug, film, found, fresh, tt.wantTmdb, tt.wantFound, tt.wantFresh)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package letterboxd
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var ErrInvalidExport = errors.New("invalid Letterboxd export")
+
+// ExportEntry is a row of a CSV file of a Letterboxd data export. Position is
+// only set for list entries.
+type ExportEntry struct {
+	Date     string
+	Name     string
+	Year     int
+	Uri      string
+	Position int
+}
+
+// Export holds the files of a Letterboxd data export. Lists are keyed by the
+// name of their CSV file without extension.
+type Export struct {
+	Watchlist []ExportEntry
+	Watched   []ExportEntry
+	Ratings   []ExportEntry
+	Diary     []ExportEntry
+	Lists     map[string][]ExportEntry
+}
+
+// ReadExport parses the ZIP file downloaded from the Letterboxd data export
+// settings. Files the sync does not use (reviews, likes, deleted entries...)
+// are ignored.
+func ReadExport(zipPath string) (*Export, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidExport, err)
+	}
+	defer archive.Close()
+
+	export := &Export{Lists: make(map[string][]ExportEntry)}
+	for _, file := range archive.File {
+		var target *[]ExportEntry
+		var listName string
+		headerColumn := "Letterboxd URI"
+
+		switch {
+		case file.Name == "watchlist.csv":
+			target = &export.Watchlist
+		case file.Name == "watched.csv":
+			target = &export.Watched
+		case file.Name == "ratings.csv":
+			target = &export.Ratings
+		case file.Name == "diary.csv":
+			target = &export.Diary
+		case path.Dir(file.Name) == "lists" && path.Ext(file.Name) == ".csv":
+			// List files start with the list metadata, the entries come after
+			// their own header.
+			headerColumn = "Position"
+			listName = strings.TrimSuffix(path.Base(file.Name), ".csv")
+		default:
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidExport, file.Name, err)
+		}
+		entries, err := readExportEntries(reader, headerColumn)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidExport, file.Name, err)
+		}
+
+		if listName != "" {
+			export.Lists[listName] = entries
+		} else {
+			*target = entries
+		}
+	}
+
+	return export, nil
+}
+
+// readExportEntries reads the rows following the first header that contains
+// headerColumn.
+func readExportEntries(reader io.Reader, headerColumn string) ([]ExportEntry, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	var header []string
+	var entries []ExportEntry
+	for {
+		record, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header == nil {
+			if slices.Contains(record, headerColumn) {
+				header = record
+			}
+			continue
+		}
+		if len(record) == 1 && record[0] == "" {
+			continue
+		}
+
+		column := func(names ...string) string {
+			for _, name := range names {
+				if index := slices.Index(header, name); index >= 0 && index < len(record) {
+					return record[index]
+				}
+			}
+			return ""
+		}
+
+		entry := ExportEntry{
+			Date: column("Date"),
+			Name: column("Name"),
+			Uri:  column("Letterboxd URI", "URL"),
+		}
+		entry.Year, _ = strconv.Atoi(column("Year"))
+		entry.Position, _ = strconv.Atoi(column("Position"))
+		if entry.Uri != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	if header == nil {
+		return nil, fmt.Errorf("no header with a %q column", headerColumn)
+	}
+	return entries, nil
+}
+
+// uriSlug returns the slug under which the film of a Letterboxd URI of an
+// export is cached. Film URLs share the cache entries of the scraped slugs,
+// boxd.it short links are cached under the link itself.
+func uriSlug(uri string) string {
+	uri = strings.TrimSpace(uri)
+	if slug := strings.TrimPrefix(uri, letterboxdUrl); strings.HasPrefix(slug, "film/") {
+		if !strings.HasSuffix(slug, "/") {
+			slug += "/"
+		}
+		return slug
+	}
+	return uri
+}
+
+// ResolveUri returns the film of a Letterboxd URI of an export.
+func (ls LetterboxdScrapper) ResolveUri(ctx context.Context, uri string) (Film, error) {
+	return ls.resolveSlug(ctx, uriSlug(uri))
+}
+
+// ResolveUris resolves the URIs concurrently like the watchlist pages, the
+// results are in the order of the URIs.
+func (ls LetterboxdScrapper) ResolveUris(ctx context.Context, uris []string) ([]Film, []error) {
+	slugs := make([]string, len(uris))
+	for index, uri := range uris {
+		slugs[index] = uriSlug(uri)
+	}
+	return ls.resolveSlugs(ctx, slugs)
+}
+
+// CachedUri returns the film of a Letterboxd URI of an export from the cache,
+// without fetching anything. Expired entries are returned too.
+func (ls LetterboxdScrapper) CachedUri(uri string) (Film, bool) {
+	if ls.Cache == nil {
+		return Film{}, false
+	}
+	film, found, _ := ls.Cache.Get(uriSlug(uri))
+	return film, found
+}
+
+// ResolveDiaryUris resolves the URIs of diary.csv like ResolveUris. They link
+// to the diary entries rather than to the films, the film is read from the
+// poster of the entry page and cached under the URI of the entry.
+func (ls LetterboxdScrapper) ResolveDiaryUris(ctx context.Context, uris []string) ([]Film, []error) {
+	return ls.resolveEach(ctx, uris, ls.resolveDiaryUri)
+}
+
+func (ls LetterboxdScrapper) resolveDiaryUri(ctx context.Context, uri string) (Film, error) {
+	slug := uriSlug(uri)
+	if strings.HasPrefix(slug, "film/") {
+		return ls.resolveSlug(ctx, slug)
+	}
+	if ls.Cache != nil {
+		if cached, found, fresh := ls.Cache.Get(slug); found && fresh {
+			return cached, nil
+		}
+	}
+
+	node, err := ls.letterboxdGetFetcher(ctx, slug)
+	if err != nil {
+		return Film{}, err
+	}
+	poster := posterSelector.SelectFirst(node)
+	if poster == nil {
+		return Film{}, fmt.Errorf("%w: no film in %s", ErrParse, slug)
+	}
+	film, err := ls.resolveSlug(ctx, posterSlugs([]*html.Node{poster})[0])
+	if err != nil {
+		return Film{}, err
+	}
+
+	if ls.Cache != nil {
+		entry := film
+		entry.Slug = slug
+		ls.Cache.Put(entry)
+	}
+	return film, nil
+}
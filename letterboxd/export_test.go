@@ -0,0 +1,143 @@
+package letterboxd
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTestExport(t *testing.T, files map[string]string) string {
+	zipPath := filepath.Join(t.TempDir(), "letterboxd-export.zip")
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return zipPath
+}
+
+func TestReadExport(t *testing.T) {
+	zipPath := writeTestExport(t, map[string]string{
+		"watchlist.csv": "Date,Name,Year,Letterboxd URI\n" +
+			"2024-01-02,Alien,1979,https://boxd.it/2bg8\n" +
+			"2024-01-03,\"Crouching Tiger, Hidden Dragon\",2000,https://boxd.it/1Wla\n",
+		"ratings.csv": "Date,Name,Year,Letterboxd URI,Rating\n" +
+			"2024-02-01,Aliens,1986,https://boxd.it/2bhY,4.5\n",
+		"lists/top-3.csv": "Letterboxd list export v7\n" +
+			"Date,Name,Tags,URL,Description\n" +
+			"2024-03-01,Top 3,,https://letterboxd.com/user/list/top-3/,\n" +
+			"\n" +
+			"Position,Name,Year,URL,Description\n" +
+			"1,Aliens,1986,https://letterboxd.com/film/aliens/,\n" +
+			"2,Alien,1979,https://letterboxd.com/film/alien/,\n",
+		"deleted/watchlist.csv": "Date,Name,Year,Letterboxd URI\n" +
+			"2024-01-01,Alien 3,1992,https://boxd.it/2bg6\n",
+	})
+
+	export, err := ReadExport(zipPath)
+	if err != nil {
+		t.Fatalf("ReadExport() returned error: %v", err)
+	}
+
+	want := &Export{
+		Watchlist: []ExportEntry{
+			{Date: "2024-01-02", Name: "Alien", Year: 1979, Uri: "https://boxd.it/2bg8"},
+			{Date: "2024-01-03", Name: "Crouching Tiger, Hidden Dragon", Year: 2000, Uri: "https://boxd.it/1Wla"},
+		},
+		Ratings: []ExportEntry{
+			{Date: "2024-02-01", Name: "Aliens", Year: 1986, Uri: "https://boxd.it/2bhY"},
+		},
+		Lists: map[string][]ExportEntry{
+			"top-3": {
+				{Name: "Aliens", Year: 1986, Uri: "https://letterboxd.com/film/aliens/", Position: 1},
+				{Name: "Alien", Year: 1979, Uri: "https://letterboxd.com/film/alien/", Position: 2},
+			},
+		},
+	}
+	if !reflect.DeepEqual(export, want) {
+		t.Errorf("ReadExport() = %+v, want %+v", export, want)
+	}
+
+	if _, err := ReadExport(writeTestExport(t, map[string]string{"watched.csv": "not,a,letterboxd,file\n"})); err == nil {
+		t.Errorf("ReadExport() without header returned no error")
+	}
+}
+
+func TestResolveUri(t *testing.T) {
+	cache, err := LoadSlugCache(filepath.Join(t.TempDir(), "slug_cache.json"), 0)
+	if err != nil {
+		t.Fatalf("LoadSlugCache() returned error: %v", err)
+	}
+	cache.Put(Film{Slug: "film/alien/", TmdbId: "348", MediaType: "movie", FetchedAt: time.Now()})
+	cache.Put(Film{Slug: "https://boxd.it/2bhY", TmdbId: "679", MediaType: "movie", FetchedAt: time.Now()})
+
+	scrapper := LetterboxdScrapper{Client: new(MockClient), Cache: cache}
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{uri: "https://letterboxd.com/film/alien", want: "348"},
+		{uri: "https://letterboxd.com/film/alien/", want: "348"},
+		{uri: "https://boxd.it/2bhY", want: "679"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			film, err := scrapper.ResolveUri(context.Background(), tt.uri)
+			if err != nil {
+				t.Fatalf("ResolveUri() returned error: %v", err)
+			}
+			if film.TmdbId != tt.want {
+				t.Errorf("ResolveUri() = %v, want %v", film.TmdbId, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDiaryUris(t *testing.T) {
+	cache, err := LoadSlugCache(filepath.Join(t.TempDir(), "slug_cache.json"), 0)
+	if err != nil {
+		t.Fatalf("LoadSlugCache() returned error: %v", err)
+	}
+	cache.Put(Film{Slug: "film/alien/", TmdbId: "348", MediaType: "movie", FetchedAt: time.Now()})
+	cache.Put(Film{Slug: "https://boxd.it/cached", TmdbId: "679", MediaType: "movie", FetchedAt: time.Now()})
+
+	mockClient := new(MockClient)
+	mockClient.On("FetchData", "https://boxd.it/entry").Return(listPage(listItem("alien", "")), nil).Once()
+	mockClient.On("FetchData", "https://boxd.it/missing").Return([]byte(`<html><body></body></html>`), nil)
+	scrapper := LetterboxdScrapper{Client: mockClient, Cache: cache}
+
+	films, errs := scrapper.ResolveDiaryUris(context.Background(), []string{"https://boxd.it/entry", "https://boxd.it/cached", "https://letterboxd.com/film/alien/", "https://boxd.it/missing"})
+	for index, want := range []string{"348", "679", "348"} {
+		if errs[index] != nil || films[index].TmdbId != want {
+			t.Errorf("ResolveDiaryUris()[%d] = %v (%v), want %v", index, films[index].TmdbId, errs[index], want)
+		}
+	}
+	if !errors.Is(errs[3], ErrParse) {
+		t.Errorf("ResolveDiaryUris() of an entry without film error = %v, want %v", errs[3], ErrParse)
+	}
+
+	if film, ok := scrapper.CachedUri("https://boxd.it/entry"); !ok || film.TmdbId != "348" {
+		t.Errorf("CachedUri() of the resolved entry = %v, %v, want the cached film", film, ok)
+	}
+	if _, ok := scrapper.CachedUri("https://boxd.it/unknown"); ok {
+		t.Errorf("CachedUri() of an unknown entry found a film")
+	}
+	mockClient.AssertExpectations(t)
+}
@@ -1,9 +1,15 @@
 package letterboxd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/net/html"
@@ -12,160 +18,387 @@ import (
 	gs "diikstra.fr/letterboxd-jellyfin-go/gosoup"
 )
 
-var ErrParse = fmt.Errorf("fail to parse")
+var (
+	ErrParse = errors.New("fail to parse")
+	// ErrScrapeBlocked is returned when Letterboxd still refuses the requests
+	// (403 or 429) after the retries, usually because the proxy is flagged.
+	ErrScrapeBlocked = errors.New("scraping blocked by Letterboxd")
+	ErrPageNotFound  = errors.New("Letterboxd page not found")
+	ErrUserNotFound  = errors.New("Letterboxd user not found")
+	// ErrIncompleteWatchlist is returned with the resolved entries when some
+	// entries of a watchlist could not be resolved.
+	ErrIncompleteWatchlist = errors.New("incomplete Letterboxd watchlist")
+)
 
 const numMoviesWatchlistPage = 28
+const numMoviesListPage = 100
 const letterboxdUrl = "https://letterboxd.com/"
 
+// Letterboxd answers 403 when the proxy gets flagged, waiting a bit before
+// retrying is usually enough.
+var letterboxdRetryPolicy = f.RetryPolicy{
+	MaxAttempts:     3,
+	BaseDelay:       60 * time.Second,
+	MaxDelay:        5 * time.Minute,
+	RetryableStatus: append([]int{403}, f.DefaultRetryPolicy.RetryableStatus...),
+}
+
+var (
+	filmBodySelector = gs.MustCompile("body.film[data-tmdb-id]")
+	posterSelector   = gs.MustCompile("div.film-poster[data-target-link]")
+	imdbLinkSelector = gs.MustCompile("a[data-track-action=IMDb][href]")
+	// Ranked lists add a p.list-number next to the poster of each item.
+	listItemSelector   = gs.MustCompile("ul.poster-list > li")
+	listNumberSelector = gs.MustCompile("p.list-number")
+)
+
+var imdbIdRegexp = regexp.MustCompile(`tt\d+`)
+
 type LetterboxdScrapper struct {
 	Client f.FetcherClient
+	// Cache is optional, without it every film page is fetched.
+	Cache *SlugCache
+	// Number of film pages resolved concurrently, 0 resolves them one by one.
+	Workers int
 }
 
-func (ls LetterboxdScrapper) letterboxdGetFetcher(endpoint string) (*html.Node, error) {
-	body, err := ls.Client.FetchData(f.FetcherParams{
+func (ls LetterboxdScrapper) letterboxdGetFetcher(ctx context.Context, endpoint string) (*html.Node, error) {
+	body, err := ls.Client.FetchDataContext(ctx, f.FetcherParams{
 		Method:   "GET",
 		Url:      endpoint,
 		UseProxy: true,
+		Retry:    &letterboxdRetryPolicy,
 	})
 
+	var statusErr *f.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case 403, 429:
+			return nil, fmt.Errorf("%w: %w", ErrScrapeBlocked, err)
+		case 404:
+			return nil, fmt.Errorf("%w: %w", ErrPageNotFound, err)
+		}
+	}
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
 
 	parsedBody, err := html.Parse(strings.NewReader(string(body)))
 
 	if err != nil {
-		return nil, ErrParse
+		return nil, fmt.Errorf("%w: %s: %w", ErrParse, endpoint, err)
 	}
 
 	return parsedBody, nil
 }
 
-func (ls LetterboxdScrapper) letterboxdGetFetcherWithRetry(endpoint string) (*html.Node, error) {
-	numFetch := 0
-	var err error
-	for numFetch < 3 {
-		node, err := ls.letterboxdGetFetcher(endpoint)
+// getTmdbIdFromSlug parses the film page of the slug, which can also be an
+// absolute URL such as the boxd.it links of the data exports.
+func (ls LetterboxdScrapper) getTmdbIdFromSlug(ctx context.Context, dataTargetLink string) (Film, error) {
+	pageUrl := letterboxdUrl + dataTargetLink
+	if strings.HasPrefix(dataTargetLink, "https://") {
+		pageUrl = dataTargetLink
+	}
 
-		if err == nil {
-			return node, nil
-		}
-		numFetch += 1
-		fmt.Println("fetch failed, retrying...")
+	node, err := ls.letterboxdGetFetcher(ctx, pageUrl)
 
-		time.Sleep(60 * time.Second)
+	if err != nil {
+		return Film{}, err
+	}
+
+	body := filmBodySelector.SelectFirst(node)
+	if body == nil {
+		return Film{}, fmt.Errorf("%w: no film id in %s", ErrParse, pageUrl)
+	}
+
+	film := Film{
+		Slug:      dataTargetLink,
+		TmdbId:    gs.GetAttribute(body, "data-tmdb-id"),
+		MediaType: gs.GetAttribute(body, "data-tmdb-type"),
+		FetchedAt: time.Now(),
 	}
-	fmt.Println("fetch failed after 3 retries, aborting...")
-	return nil, err
+	if film.MediaType == "" {
+		film.MediaType = "movie"
+	}
+	if imdbLink := imdbLinkSelector.SelectFirst(node); imdbLink != nil {
+		film.ImdbId = imdbIdRegexp.FindString(gs.GetAttribute(imdbLink, "href"))
+	}
+
+	return film, nil
 }
 
-func (ls LetterboxdScrapper) getTmdbIdFromSlug(dataTargetLink string) (string, error) {
-	node, err := ls.letterboxdGetFetcherWithRetry(letterboxdUrl + dataTargetLink)
+// getFilmFromSlug resolves a film slug through the cache, only fetching the
+// film page when the slug is unknown or its entry expired.
+func (ls LetterboxdScrapper) getFilmFromSlug(ctx context.Context, dataTargetLink string) (Film, error) {
+	if ls.Cache == nil {
+		return ls.getTmdbIdFromSlug(ctx, dataTargetLink)
+	}
 
+	cached, found, fresh := ls.Cache.Get(dataTargetLink)
+	if found && fresh {
+		return cached, nil
+	}
+
+	film, err := ls.getTmdbIdFromSlug(ctx, dataTargetLink)
 	if err != nil {
-		log.Println(err)
-		return "", err
+		if found && ctx.Err() == nil {
+			log.Printf("Failed to revalidate %s, using cached entry: %v", dataTargetLink, err)
+			return cached, nil
+		}
+		return Film{}, err
 	}
 
-	body := gs.GetNodeByClass(node, &gs.HtmlSelector{
-		ClassNames: "film",
-		Tag:        "body",
-		Multiple:   false,
-	})
+	ls.Cache.Put(film)
+	return film, nil
+}
+
+// resolveSlug returns the film of a slug.
+func (ls LetterboxdScrapper) resolveSlug(ctx context.Context, slug string) (Film, error) {
+	film, err := ls.getFilmFromSlug(ctx, slug)
+	fmt.Printf("%s -> %s\n", slug, film.TmdbKey())
+	return film, err
+}
+
+// resolveSlugs resolves the slugs with Workers concurrent workers, the
+// results are in the order of the slugs. The request rate is bounded by the
+// rate limiter of the fetcher, not by the number of workers.
+func (ls LetterboxdScrapper) resolveSlugs(ctx context.Context, slugs []string) ([]Film, []error) {
+	return ls.resolveEach(ctx, slugs, ls.resolveSlug)
+}
+
+// resolveEach resolves the keys with resolve like resolveSlugs.
+func (ls LetterboxdScrapper) resolveEach(ctx context.Context, slugs []string, resolve func(context.Context, string) (Film, error)) ([]Film, []error) {
+	films := make([]Film, len(slugs))
+	errs := make([]error, len(slugs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(ls.Workers, 1), len(slugs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				films[index], errs[index] = resolve(ctx, slugs[index])
+			}
+		}()
+	}
+	for index := range slugs {
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+
+	return films, errs
+}
+
+func posterSlugs(posters []*html.Node) []string {
+	slugs := make([]string, len(posters))
+	for index, poster := range posters {
+		slugs[index] = strings.TrimPrefix(gs.GetAttribute(poster, "data-target-link"), "/")
+	}
+	return slugs
+}
+
+// resolvePosters returns the films of the posters of a page, see
+// resolveSlugs.
+func (ls LetterboxdScrapper) resolvePosters(ctx context.Context, posters []*html.Node) ([]Film, []error) {
+	return ls.resolveSlugs(ctx, posterSlugs(posters))
+}
 
-	return gs.GetAttribute(body[0], "data-tmdb-id"), nil
+// isMarker reports whether the cache knows the slug as the film whose TmdbKey
+// is marker, so that the walk stops without fetching its page.
+func (ls LetterboxdScrapper) isMarker(slug string, marker string) bool {
+	if ls.Cache == nil || marker == "" {
+		return false
+	}
+	film, found, _ := ls.Cache.Get(slug)
+	return found && film.TmdbKey() == marker
 }
 
-func (ls LetterboxdScrapper) GetNewestUserWatchlist(userName string, latestFetched *string) ([]string, error) {
+// GetNewestUserWatchlist returns the watchlist entries added since the one
+// whose TmdbKey is latestFetched, newest first, and moves the marker to the
+// newest entry. The entries are resolved in batches of Workers in the order
+// of the watchlist, so that nothing past the marker is fetched.
+//
+// The walk stops at the first entry failing to resolve, which may be the
+// marker: the entries found before it are returned with
+// ErrIncompleteWatchlist and the marker is left in place for the next run.
+func (ls LetterboxdScrapper) GetNewestUserWatchlist(ctx context.Context, userName string, latestFetched *string) ([]Film, error) {
 	pageIndex := 1
-	var tmdbIds []string
+	var films []Film
 
 	for pageIndex > 0 {
 		fmt.Printf("Fetching page %d\n", pageIndex)
-		node, err := ls.letterboxdGetFetcherWithRetry(letterboxdUrl + userName + "/watchlist/page/" + fmt.Sprint(pageIndex))
+		node, err := ls.letterboxdGetFetcher(ctx, letterboxdUrl+userName+"/watchlist/page/"+fmt.Sprint(pageIndex))
 
+		// The marker only moves once every new entry was seen.
 		if err != nil {
-			log.Println(err)
-			break
+			return nil, watchlistPageError(userName, pageIndex, err)
 		}
 
-		posters := gs.GetNodeByClass(node, &gs.HtmlSelector{
-			ClassNames: "really-lazy-load poster film-poster",
-			Tag:        "div",
-			Multiple:   true,
-		})
+		posters := posterSelector.Select(node)
 
 		if len(posters) < numMoviesWatchlistPage {
 			pageIndex = -1
 		}
 
-		for _, poster := range posters {
-			dataTargetLink := gs.GetAttribute(poster, "data-target-link")
-			tmdbId, err := ls.getTmdbIdFromSlug(dataTargetLink[1:])
-			fmt.Printf("%s -> %s\n", dataTargetLink, tmdbId)
-			if err != nil {
-				log.Println(err)
-				continue
-			}
+		// A marker known by its slug is not fetched again.
+		slugs := posterSlugs(posters)
+		if index := slices.IndexFunc(slugs, func(slug string) bool { return ls.isMarker(slug, *latestFetched) }); index >= 0 {
+			slugs = slugs[:index]
+			pageIndex = -1
+		}
 
-			if *latestFetched == tmdbId {
-				pageIndex = -1
-				break
+		batchSize := max(ls.Workers, 1)
+	batches:
+		for start := 0; start < len(slugs); start += batchSize {
+			batchFilms, errs := ls.resolveSlugs(ctx, slugs[start:min(start+batchSize, len(slugs))])
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			for index, film := range batchFilms {
+				if errors.Is(errs[index], ErrScrapeBlocked) {
+					return nil, errs[index]
+				}
+				if errs[index] != nil {
+					log.Println(errs[index])
+					return films, fmt.Errorf("%w: stopped at an entry of %s failing to resolve: %w", ErrIncompleteWatchlist, userName, errs[index])
+				}
+
+				if *latestFetched == film.TmdbKey() {
+					pageIndex = -1
+					break batches
+				}
+
+				films = append(films, film)
 			}
-
-			tmdbIds = append(tmdbIds, tmdbId)
-
-			time.Sleep(60 * time.Second)
 		}
 		pageIndex += 1
 	}
 
-	if len(tmdbIds) > 0 {
-		*latestFetched = tmdbIds[0]
+	if len(films) > 0 {
+		*latestFetched = films[0].TmdbKey()
 	}
 
-	return tmdbIds, nil
+	return films, nil
 }
 
-func (ls LetterboxdScrapper) GetFullUserWatchlist(userName string) ([]string, error) {
+// GetFullUserWatchlist returns every entry of the watchlist, newest first.
+// The entries that fail to resolve are left out and reported with
+// ErrIncompleteWatchlist, along with the entries that were resolved.
+func (ls LetterboxdScrapper) GetFullUserWatchlist(ctx context.Context, userName string) ([]Film, error) {
 	pageIndex := 1
-	var tmdbIds []string
+	var films []Film
+	var failed []error
 
 	for pageIndex > 0 {
 		fmt.Printf("Fetching page %d\n", pageIndex)
-		node, err := ls.letterboxdGetFetcherWithRetry(letterboxdUrl + userName + "/watchlist/page/" + fmt.Sprint(pageIndex))
+		node, err := ls.letterboxdGetFetcher(ctx, letterboxdUrl+userName+"/watchlist/page/"+fmt.Sprint(pageIndex))
 
+		// A partial watchlist would make the reconciliation wrong, fail instead.
 		if err != nil {
-			log.Println(err)
-			break
+			return nil, watchlistPageError(userName, pageIndex, err)
 		}
 
-		posters := gs.GetNodeByClass(node, &gs.HtmlSelector{
-			ClassNames: "really-lazy-load poster film-poster",
-			Tag:        "div",
-			Multiple:   true,
-		})
+		posters := posterSelector.Select(node)
 
 		if len(posters) < numMoviesWatchlistPage {
 			pageIndex = -1
 		}
 
-		for _, poster := range posters {
-			dataTargetLink := gs.GetAttribute(poster, "data-target-link")
-			tmdbId, err := ls.getTmdbIdFromSlug(dataTargetLink[1:])
-			fmt.Printf("%s -> %s\n", dataTargetLink, tmdbId)
-			if err != nil {
-				log.Println(err)
+		pageFilms, errs := ls.resolvePosters(ctx, posters)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for index, film := range pageFilms {
+			if errors.Is(errs[index], ErrScrapeBlocked) {
+				return nil, errs[index]
+			}
+			if errs[index] != nil {
+				log.Println(errs[index])
+				failed = append(failed, errs[index])
 				continue
 			}
+			films = append(films, film)
+		}
+		pageIndex += 1
+	}
+
+	if len(failed) > 0 {
+		return films, fmt.Errorf("%w: %d entries of %s failed to resolve: %w", ErrIncompleteWatchlist, len(failed), userName, errors.Join(failed...))
+	}
+	return films, nil
+}
 
-			tmdbIds = append(tmdbIds, tmdbId)
+// watchlistPageError reports a missing first page as an unknown user.
+func watchlistPageError(userName string, pageIndex int, err error) error {
+	if pageIndex == 1 && errors.Is(err, ErrPageNotFound) {
+		return fmt.Errorf("%w: %s: %w", ErrUserNotFound, userName, err)
+	}
+	return err
+}
+
+// ListEntry is a film of a Letterboxd list with its 1-based position. Ranked
+// lists use the number Letterboxd displays, other lists the display order.
+type ListEntry struct {
+	Film
+	Position int
+}
 
-			time.Sleep(60 * time.Second)
+// GetList scrapes every page of the public list of owner identified by its
+// slug (the last part of https://letterboxd.com/<owner>/list/<slug>/).
+func (ls LetterboxdScrapper) GetList(ctx context.Context, owner string, slug string) ([]ListEntry, error) {
+	pageIndex := 1
+	position := 0
+	var entries []ListEntry
+
+	for pageIndex > 0 {
+		fmt.Printf("Fetching list %s/%s page %d\n", owner, slug, pageIndex)
+		node, err := ls.letterboxdGetFetcher(ctx, letterboxdUrl+owner+"/list/"+slug+"/page/"+fmt.Sprint(pageIndex)+"/")
+		if err != nil {
+			return nil, err
+		}
+
+		items := listItemSelector.Select(node)
+		if len(items) < numMoviesListPage {
+			pageIndex = -1
+		}
+
+		var posters []*html.Node
+		var positions []int
+		for _, item := range items {
+			position += 1
+			poster := posterSelector.SelectFirst(item)
+			if poster == nil {
+				continue
+			}
+
+			entryPosition := position
+			if number := listNumberSelector.SelectFirst(item); number != nil {
+				if rank, err := strconv.Atoi(strings.TrimSpace(gs.GetText(number))); err == nil {
+					entryPosition = rank
+				}
+			}
+			posters = append(posters, poster)
+			positions = append(positions, entryPosition)
+		}
+
+		films, errs := ls.resolvePosters(ctx, posters)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for index, film := range films {
+			if errors.Is(errs[index], ErrScrapeBlocked) {
+				return nil, errs[index]
+			}
+			if errs[index] != nil {
+				log.Println(errs[index])
+				continue
+			}
+			entries = append(entries, ListEntry{Film: film, Position: positions[index]})
 		}
 		pageIndex += 1
 	}
 
-	return tmdbIds, nil
+	return entries, nil
 }
@@ -0,0 +1,215 @@
+package letterboxd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+)
+
+type MockClient struct {
+	mock.Mock
+}
+
+func (m *MockClient) FetchData(fp f.FetcherParams) ([]byte, error) {
+	args := m.Called(fp.Url)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockClient) FetchDataContext(ctx context.Context, fp f.FetcherParams) ([]byte, error) {
+	return m.FetchData(fp)
+}
+
+func listPage(items ...string) []byte {
+	return []byte(`<html><body><ul class="poster-list -p125 -grid film-list">` + strings.Join(items, "") + `</ul></body></html>`)
+}
+
+func listItem(slug string, rank string) string {
+	item := `<li class="poster-container"><div class="really-lazy-load poster film-poster" data-target-link="/film/` + slug + `/"></div>`
+	if rank != "" {
+		item += `<p class="list-number">` + rank + `</p>`
+	}
+	return item + `</li>`
+}
+
+func TestGetList(t *testing.T) {
+	cache, err := LoadSlugCache(filepath.Join(t.TempDir(), "slug_cache.json"), 0)
+	if err != nil {
+		t.Fatalf("LoadSlugCache() returned error: %v", err)
+	}
+	cache.Put(Film{Slug: "film/alien/", TmdbId: "348", MediaType: "movie", FetchedAt: time.Now()})
+	cache.Put(Film{Slug: "film/aliens/", TmdbId: "679", MediaType: "movie", FetchedAt: time.Now()})
+	cache.Put(Film{Slug: "film/chernobyl/", TmdbId: "87108", MediaType: "tv", FetchedAt: time.Now()})
+
+	tests := []struct {
+		name string
+		page []byte
+		want []ListEntry
+	}{
+		{
+			name: "Test unranked list",
+			page: listPage(listItem("alien", ""), listItem("chernobyl", "")),
+			want: []ListEntry{
+				{Film: Film{Slug: "film/alien/", TmdbId: "348", MediaType: "movie"}, Position: 1},
+				{Film: Film{Slug: "film/chernobyl/", TmdbId: "87108", MediaType: "tv"}, Position: 2},
+			},
+		},
+		{
+			name: "Test ranked list",
+			page: listPage(listItem("aliens", "11"), listItem("alien", "12")),
+			want: []ListEntry{
+				{Film: Film{Slug: "film/aliens/", TmdbId: "679", MediaType: "movie"}, Position: 11},
+				{Film: Film{Slug: "film/alien/", TmdbId: "348", MediaType: "movie"}, Position: 12},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockClient)
+			mockClient.On("FetchData", "https://letterboxd.com/owner/list/top/page/1/").Return(tt.page, nil)
+
+			scrapper := LetterboxdScrapper{Client: mockClient, Cache: cache, Workers: 3}
+			got, err := scrapper.GetList(context.Background(), "owner", "top")
+			if err != nil {
+				t.Fatalf("GetList() returned error: %v", err)
+			}
+
+			for i := range got {
+				got[i].FetchedAt = time.Time{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFullUserWatchlistErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		want       error
+	}{
+		{name: "Test unknown user", statusCode: 404, want: ErrUserNotFound},
+		{name: "Test blocked proxy", statusCode: 403, want: ErrScrapeBlocked},
+		{name: "Test rate limited", statusCode: 429, want: ErrScrapeBlocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockClient)
+			mockClient.On("FetchData", "https://letterboxd.com/someone/watchlist/page/1").Return([]byte{}, &f.StatusError{StatusCode: tt.statusCode})
+
+			_, err := LetterboxdScrapper{Client: mockClient}.GetFullUserWatchlist(context.Background(), "someone")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GetFullUserWatchlist() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFullUserWatchlistUnresolved(t *testing.T) {
+	cache, err := LoadSlugCache(filepath.Join(t.TempDir(), "slug_cache.json"), 0)
+	if err != nil {
+		t.Fatalf("LoadSlugCache() returned error: %v", err)
+	}
+	cache.Put(Film{Slug: "film/alien/", TmdbId: "348", MediaType: "movie", FetchedAt: time.Now()})
+
+	mockClient := new(MockClient)
+	mockClient.On("FetchData", "https://letterboxd.com/someone/watchlist/page/1").Return(listPage(listItem("alien", ""), listItem("removed", "")), nil)
+	mockClient.On("FetchData", "https://letterboxd.com/film/removed/").Return([]byte{}, &f.StatusError{StatusCode: 404})
+
+	scrapper := LetterboxdScrapper{Client: mockClient, Cache: cache}
+	got, err := scrapper.GetFullUserWatchlist(context.Background(), "someone")
+	if !errors.Is(err, ErrIncompleteWatchlist) {
+		t.Errorf("GetFullUserWatchlist() error = %v, want %v", err, ErrIncompleteWatchlist)
+	}
+	if len(got) != 1 || got[0].TmdbId != "348" {
+		t.Errorf("GetFullUserWatchlist() = %v, want the resolved entry", got)
+	}
+}
+
+func TestGetNewestUserWatchlist(t *testing.T) {
+	const pageUrl = "https://letterboxd.com/someone/watchlist/page/1"
+	alienPage := []byte(`<html><body class="film" data-tmdb-id="348" data-tmdb-type="movie"></body></html>`)
+
+	tests := []struct {
+		name string
+		page []byte
+		// Film pages by url, the others fail the test when fetched.
+		filmPages  map[string]error
+		cached     []Film
+		want       []string
+		wantLatest string
+		wantErr    error
+	}{
+		{
+			name:       "Test marker known by slug",
+			page:       listPage(listItem("new", ""), listItem("alien", ""), listItem("unfetched", "")),
+			cached:     []Film{{Slug: "film/new/", TmdbId: "1"}, {Slug: "film/alien/", TmdbId: "348"}},
+			want:       []string{"1"},
+			wantLatest: "1",
+		},
+		{
+			name:       "Test marker resolved",
+			page:       listPage(listItem("new", ""), listItem("alien", ""), listItem("unfetched", "")),
+			filmPages:  map[string]error{"https://letterboxd.com/film/alien/": nil},
+			cached:     []Film{{Slug: "film/new/", TmdbId: "1"}},
+			want:       []string{"1"},
+			wantLatest: "1",
+		},
+		{
+			name:       "Test no new entry",
+			page:       listPage(listItem("alien", ""), listItem("unfetched", "")),
+			cached:     []Film{{Slug: "film/alien/", TmdbId: "348"}},
+			wantLatest: "348",
+		},
+		{
+			name:       "Test entry failing to resolve",
+			page:       listPage(listItem("new", ""), listItem("broken", ""), listItem("unfetched", "")),
+			filmPages:  map[string]error{"https://letterboxd.com/film/broken/": &f.StatusError{StatusCode: 404}},
+			cached:     []Film{{Slug: "film/new/", TmdbId: "1"}},
+			want:       []string{"1"},
+			wantLatest: "348",
+			wantErr:    ErrIncompleteWatchlist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := LoadSlugCache(filepath.Join(t.TempDir(), "slug_cache.json"), 0)
+			if err != nil {
+				t.Fatalf("LoadSlugCache() returned error: %v", err)
+			}
+			for _, film := range tt.cached {
+				film.MediaType = "movie"
+				film.FetchedAt = time.Now()
+				cache.Put(film)
+			}
+			mockClient := new(MockClient)
+			mockClient.On("FetchData", pageUrl).Return(tt.page, nil)
+			for url, err := range tt.filmPages {
+				mockClient.On("FetchData", url).Return(alienPage, err)
+			}
+
+			latest := "348"
+			got, err := LetterboxdScrapper{Client: mockClient, Cache: cache}.GetNewestUserWatchlist(context.Background(), "someone", &latest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetNewestUserWatchlist() error = %v, want %v", err, tt.wantErr)
+			}
+			var gotKeys []string
+			for _, film := range got {
+				gotKeys = append(gotKeys, film.TmdbKey())
+			}
+			if !reflect.DeepEqual(gotKeys, tt.want) || latest != tt.wantLatest {
+				t.Errorf("GetNewestUserWatchlist() = %v, marker %s, want %v, marker %s", gotKeys, latest, tt.want, tt.wantLatest)
+			}
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"diikstra.fr/letterboxd-jellyfin-go/config"
 	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
-	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
 	lt "diikstra.fr/letterboxd-jellyfin-go/letterboxd"
-	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
+	"diikstra.fr/letterboxd-jellyfin-go/state"
 )
 
 var (
@@ -20,49 +29,314 @@ var (
 	basepath   = filepath.Dir(b)
 )
 
+var (
+	dryRun      = flag.Bool("dry-run", false, "print what the run would do without changing Radarr, Jellyfin or the state")
+	planFormat  = flag.String("plan-format", "table", "format of the dry-run plan, table or json")
+	forceUnlock = flag.Bool("force-unlock", false, "same as the unlock command")
+)
+
+// ErrUsage is returned for invalid command lines, the usage is then printed.
+var ErrUsage = errors.New("invalid arguments")
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\nCommands (sync when omitted):\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-40s %s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
 func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *planFormat != "table" && *planFormat != "json" {
+		log.Fatalf("Unknown plan format %q, expected table or json.", *planFormat)
+	}
+
 	err := godotenv.Load(filepath.Join(basepath, ".env"))
 	if err != nil {
 		log.Fatalf("Error while loading env file.\nErr: %s", err)
 	}
 
-	if config.IsLocked() {
-		log.Fatal("App is locked, wait for the current run to finish.")
+	args := flag.Args()
+	if *forceUnlock {
+		args = []string{"unlock"}
+	}
+	if len(args) == 0 {
+		args = []string{"sync"}
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q.\n\n", args[0])
+		usage()
+		os.Exit(2)
 	}
-	defer config.Unlock()
 
-	conf := config.LoadConfiguration()
+	var lock *config.Lock
+	if cmd.locked {
+		lock, err = config.AcquireLock()
+		if err != nil {
+			log.Fatalf("%v\nWait for the current run to finish or use the unlock command if it is not running anymore.", err)
+		}
+	}
+
+	// The lock is released before exiting on error, log.Fatal would skip the
+	// deferred calls of runCommand.
+	err = runCommand(cmd, args[1:])
+	if lock != nil {
+		if unlockErr := lock.Release(); unlockErr != nil {
+			log.Printf("Failed to release the lock: %v", unlockErr)
+		}
+	}
+	if errors.Is(err, ErrUsage) {
+		fmt.Fprintf(flag.CommandLine.Output(), "%v\nUsage: %s %s\n", err, filepath.Base(os.Args[0]), cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	fetcher := f.Fetcher{
+// app holds what the commands share. The slug cache and the syncer are
+// created on first use.
+type app struct {
+	ctx     context.Context
+	start   time.Time
+	conf    *config.Configuration
+	store   *state.Store
+	fetcher f.Fetcher
+	runPlan *plan.Plan
+	// config.LockPath and config.SlugCachePath.
+	lockPath      string
+	slugCachePath string
+
+	slugCache *lt.SlugCache
+	syncer    *syncer
+}
+
+// runCommand loads the configuration and the state, unless the command is
+// standalone, and runs the command with the rest of the command line.
+func runCommand(cmd command, args []string) error {
+	a := &app{start: time.Now(), lockPath: config.LockPath(), slugCachePath: config.SlugCachePath()}
+
+	// SIGINT / SIGTERM and the run budget cancel every in-flight request, the
+	// state is still saved so that finished users keep it. serve applies the
+	// budget to each sync and handles the signals itself.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	a.ctx = ctx
+
+	if cmd.standalone {
+		return cmd.run(a, args)
+	}
+
+	conf, err := config.LoadConfiguration()
+	if err != nil {
+		return err
+	}
+	a.conf = &conf
+
+	a.store, err = state.Open(config.StatePath(), conf.StateBackups)
+	if err != nil {
+		return err
+	}
+	if a.store.IsNew() && filepath.Ext(config.ConfigPath()) == ".json" {
+		// Older versions kept the cursors of the users in config.json.
+		if err := a.store.ImportLegacy(config.ConfigPath()); err != nil {
+			return err
+		}
+	}
+	conf.Users = append(conf.Users, a.store.AddedUsers()...)
+
+	if conf.RunBudgetMinutes > 0 && cmd.name != "serve" {
+		var cancel context.CancelFunc
+		a.ctx, cancel = context.WithTimeout(a.ctx, time.Duration(conf.RunBudgetMinutes)*time.Minute)
+		defer cancel()
+	}
+
+	a.fetcher = f.Fetcher{
 		ProxyUrl:  conf.ProxyUrl,
 		ProxyUser: conf.ProxyUser,
 		ProxyPass: conf.ProxyPass,
+		Timeout:   time.Duration(conf.RequestTimeoutSeconds) * time.Second,
+		DryRun:    *dryRun,
+	}
+	letterboxdRequestsPerMinute := conf.LetterboxdRequestsPerMinute
+	if letterboxdRequestsPerMinute == 0 {
+		letterboxdRequestsPerMinute = config.DefaultLetterboxdRequestsPerMinute
+	}
+	letterboxdLimiter, err := f.NewRateLimiter(letterboxdRequestsPerMinute, 1)
+	if err != nil {
+		return fmt.Errorf("LetterboxdRequestsPerMinute: %w", err)
 	}
-	letterboxdScrapper := lt.LetterboxdScrapper{
-		Client: fetcher,
+	// Export short links redirect to letterboxd.com, they share its budget.
+	a.fetcher.RateLimits = map[string]*f.RateLimiter{
+		"letterboxd.com": letterboxdLimiter,
+		"boxd.it":        letterboxdLimiter,
 	}
 
-	allMovies := jf.GetAllMovies(fetcher)
+	if *dryRun {
+		a.runPlan = &plan.Plan{}
+		a.ctx = plan.WithPlan(a.ctx, a.runPlan)
+	}
 
-	for index := range conf.Users {
-		fmt.Println(conf.Users[index].Username)
-		var tmdbIds []string
+	// A dry run leaves every file as it found it.
+	defer func() {
+		if a.slugCache == nil || *dryRun {
+			return
+		}
+		if err := a.slugCache.Save(); err != nil {
+			log.Printf("Failed to save slug cache: %v", err)
+		}
+	}()
 
-		tmdbIds, err = letterboxdScrapper.GetNewestUserWatchlist(conf.Users[index].Username, &conf.Users[index].LatestWatchlistMovie)
+	return cmd.run(a, args)
+}
 
+func (a *app) loadSlugCache() (*lt.SlugCache, error) {
+	if a.slugCache == nil {
+		slugCache, err := lt.LoadSlugCache(a.slugCachePath, time.Duration(a.conf.SlugCacheTTLDays)*24*time.Hour)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("error while loading slug cache: %w", err)
 		}
+		a.slugCache = slugCache
+	}
+	return a.slugCache, nil
+}
 
-		radarrStates := rd.SendTmdbIDsToRadarr(fetcher, tmdbIds, &conf)
-
-		userId, err := jf.GetUserId(fetcher, conf.Users[index].JellyfinUserName)
+// loadSyncer checks the Radarr and Sonarr settings and loads the Jellyfin
+// library, see newSyncer.
+func (a *app) loadSyncer() (*syncer, error) {
+	if a.syncer == nil {
+		slugCache, err := a.loadSlugCache()
+		if err != nil {
+			return nil, err
+		}
+		s, err := newSyncer(a.ctx, a.fetcher, slugCache, a.store, a.conf)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		a.syncer = s
+	}
+	return a.syncer, nil
+}
+
+// finishRun prints the plan of a dry run and the summary of the run, then
+// records the run in the state and saves it.
+func (a *app) finishRun(command string, results []userResult) error {
+	if *dryRun {
+		writePlan(a.runPlan)
+	}
+
+	failed, err := writeSummary(os.Stdout, results)
+	if err != nil {
+		log.Printf("Failed to write run summary: %v", err)
+	}
+
+	if !*dryRun {
+		a.store.AddRun(state.Run{
+			Command:   command,
+			StartedAt: a.start,
+			Duration:  time.Since(a.start),
+			Users:     len(results),
+			Failed:    failed,
+		})
+		// The users that succeeded keep their state even if others failed.
+		if err := a.store.Save(); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrUsersFailed, failed, len(results))
+	}
+	return nil
+}
+
+// syncUsers syncs the users with ConcurrentUsers workers. Each worker only
+// changes the state of the user it syncs, a failing user does not stop the
+// others. The results are in the order of the users.
+func syncUsers(ctx context.Context, s *syncer, users []*config.UserData, forceFull bool, runPlan *plan.Plan) []userResult {
+	results := make([]userResult, len(users))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range max(s.conf.ConcurrentUsers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				user := users[index]
+				fmt.Println(user.Username)
+
+				before := s.state.Cursor(user.Username)
+				start := time.Now()
+				err := s.syncUser(ctx, user, forceFull)
+				recordStateChanges(runPlan, user.Username, before, s.state.Cursor(user.Username))
+				if err != nil {
+					log.Printf("Failed to sync %s: %v", user.Username, err)
+				}
+				results[index] = userResult{Username: user.Username, Err: err, Duration: time.Since(start)}
+			}
+		}()
+	}
+
+	for index := range users {
+		if ctx.Err() != nil {
+			log.Printf("Run interrupted: %v", ctx.Err())
+			for ; index < len(users); index++ {
+				results[index] = userResult{Username: users[index].Username, Err: ctx.Err(), Skipped: true}
+			}
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// findUser returns the configured user with the given Letterboxd username.
+func findUser(conf *config.Configuration, username string) (*config.UserData, error) {
+	for index := range conf.Users {
+		if strings.EqualFold(conf.Users[index].Username, username) {
+			return &conf.Users[index], nil
 		}
-		jf.RemoveSeenMoviesFromUserCollection(fetcher, userId, conf.Users[index].CollectionId)
-		jf.AddMoviesToCollection(fetcher, allMovies, radarrStates, userId, conf.Users[index].CollectionId)
 	}
+	return nil, fmt.Errorf("user %q is not in the configuration", username)
+}
+
+func writePlan(runPlan *plan.Plan) {
+	var err error
+	if *planFormat == "json" {
+		err = runPlan.WriteJSON(os.Stdout)
+	} else {
+		err = runPlan.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		log.Printf("Failed to write plan: %v", err)
+	}
+}
 
-	config.PersistChanges(conf)
+func recordStateChanges(runPlan *plan.Plan, username string, before state.Cursor, after state.Cursor) {
+	runPlan.AddConfigChange(plan.ConfigChange{
+		User:  username,
+		Field: "LatestWatchlistMovie",
+		Old:   before.LatestWatchlistMovie,
+		New:   after.LatestWatchlistMovie,
+	})
+	runPlan.AddConfigChange(plan.ConfigChange{
+		User:  username,
+		Field: "LastFullSync",
+		Old:   before.LastFullSync.Format(time.RFC3339),
+		New:   after.LastFullSync.Format(time.RFC3339),
+	})
+	runPlan.AddConfigChange(plan.ConfigChange{
+		User:  username,
+		Field: "MissingSince",
+		Old:   fmt.Sprintf("%d movies", len(before.MissingSince)),
+		New:   fmt.Sprintf("%d movies", len(after.MissingSince)),
+	})
 }
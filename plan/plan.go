@@ -0,0 +1,186 @@
+package plan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+)
+
+// RadarrAdd is a movie that would be sent to Radarr.
+type RadarrAdd struct {
+	TmdbId         string
+	Title          string
+	Year           int
+	RootFolderPath string
+	Rule           string
+}
+
+// SonarrAdd is a series that would be sent to Sonarr.
+type SonarrAdd struct {
+	TmdbId         string
+	TvdbId         string
+	Title          string
+	Year           int
+	RootFolderPath string
+	Monitor        string
+}
+
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionCreate = "create"
+)
+
+// CollectionChange is an item that would be added to or removed from a
+// Jellyfin collection, or a collection that would be created, Name being the
+// name of the collection.
+type CollectionChange struct {
+	CollectionId string
+	Action       string
+	ItemId       string
+	Name         string
+	Reason       string `json:",omitempty"`
+}
+
+// ConfigChange is a configuration field that would be persisted with a new
+// value.
+type ConfigChange struct {
+	User  string
+	Field string
+	Old   string
+	New   string
+}
+
+// Plan collects every change a run makes. In dry-run mode the fetcher refuses
+// mutating requests, so the plan is the only output of the run.
+type Plan struct {
+	mu                sync.Mutex
+	RadarrAdds        []RadarrAdd
+	SonarrAdds        []SonarrAdd
+	CollectionChanges []CollectionChange
+	ConfigChanges     []ConfigChange
+}
+
+type contextKey struct{}
+
+// WithPlan returns a context carrying the plan, the radarr and jellyfin
+// packages record their changes in it.
+func WithPlan(ctx context.Context, p *Plan) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the plan of the context or nil. Every method of Plan can
+// be called on a nil plan.
+func FromContext(ctx context.Context) *Plan {
+	p, _ := ctx.Value(contextKey{}).(*Plan)
+	return p
+}
+
+func (p *Plan) AddRadarr(change RadarrAdd) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.RadarrAdds = append(p.RadarrAdds, change)
+}
+
+func (p *Plan) AddSonarr(change SonarrAdd) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.SonarrAdds = append(p.SonarrAdds, change)
+}
+
+func (p *Plan) AddCollectionChange(change CollectionChange) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.CollectionChanges = append(p.CollectionChanges, change)
+}
+
+func (p *Plan) AddConfigChange(change ConfigChange) {
+	if p == nil || change.Old == change.New {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ConfigChanges = append(p.ConfigChanges, change)
+}
+
+func (p *Plan) IsEmpty() bool {
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.RadarrAdds) == 0 && len(p.SonarrAdds) == 0 && len(p.CollectionChanges) == 0 && len(p.ConfigChanges) == 0
+}
+
+func (p *Plan) WriteJSON(w io.Writer) error {
+	if p == nil {
+		p = &Plan{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(p)
+}
+
+func (p *Plan) WriteTable(w io.Writer) error {
+	if p == nil {
+		p = &Plan{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "RADARR ADDS (%d)\n", len(p.RadarrAdds))
+	if len(p.RadarrAdds) > 0 {
+		fmt.Fprintln(tw, "TMDB\tTITLE\tYEAR\tROOT FOLDER\tRULE")
+		for _, change := range p.RadarrAdds {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", change.TmdbId, change.Title, change.Year, change.RootFolderPath, change.Rule)
+		}
+	}
+
+	fmt.Fprintf(tw, "\nSONARR ADDS (%d)\n", len(p.SonarrAdds))
+	if len(p.SonarrAdds) > 0 {
+		fmt.Fprintln(tw, "TMDB\tTVDB\tTITLE\tYEAR\tROOT FOLDER\tMONITOR")
+		for _, change := range p.SonarrAdds {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", change.TmdbId, change.TvdbId, change.Title, change.Year, change.RootFolderPath, change.Monitor)
+		}
+	}
+
+	fmt.Fprintf(tw, "\nCOLLECTION CHANGES (%d)\n", len(p.CollectionChanges))
+	if len(p.CollectionChanges) > 0 {
+		fmt.Fprintln(tw, "COLLECTION\tACTION\tITEM\tNAME\tREASON")
+		for _, change := range p.CollectionChanges {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", change.CollectionId, change.Action, change.ItemId, change.Name, change.Reason)
+		}
+	}
+
+	fmt.Fprintf(tw, "\nCONFIG CHANGES (%d)\n", len(p.ConfigChanges))
+	if len(p.ConfigChanges) > 0 {
+		fmt.Fprintln(tw, "USER\tFIELD\tOLD\tNEW")
+		for _, change := range p.ConfigChanges {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", change.User, change.Field, change.Old, change.New)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,117 @@
+package plan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNilPlan(t *testing.T) {
+	p := FromContext(context.Background())
+	if p != nil {
+		t.Fatalf("FromContext() without plan = %v, want nil", p)
+	}
+
+	p.AddRadarr(RadarrAdd{TmdbId: "348"})
+	p.AddSonarr(SonarrAdd{TmdbId: "87108"})
+	p.AddCollectionChange(CollectionChange{CollectionId: "collection", Action: ActionAdd, ItemId: "alien"})
+	p.AddConfigChange(ConfigChange{User: "someone", Field: "User", New: "added"})
+	if !p.IsEmpty() {
+		t.Errorf("IsEmpty() of a nil plan = false, want true")
+	}
+
+	var table bytes.Buffer
+	if err := p.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable() returned error: %v", err)
+	}
+	if !strings.Contains(table.String(), "RADARR ADDS (0)") {
+		t.Errorf("WriteTable() of a nil plan = %q, want the empty sections", table.String())
+	}
+	var encoded bytes.Buffer
+	if err := p.WriteJSON(&encoded); err != nil {
+		t.Fatalf("WriteJSON() returned error: %v", err)
+	}
+	var decoded Plan
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil || !decoded.IsEmpty() {
+		t.Errorf("WriteJSON() of a nil plan = %s (%v), want an empty plan", encoded.String(), err)
+	}
+}
+
+func TestAddConfigChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		change ConfigChange
+		want   int
+	}{
+		{name: "Test changed field", change: ConfigChange{User: "someone", Field: "LatestWatchlistMovie", Old: "film/alien/", New: "film/aliens/"}, want: 1},
+		{name: "Test unchanged field", change: ConfigChange{User: "someone", Field: "LatestWatchlistMovie", Old: "film/alien/", New: "film/alien/"}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plan{}
+			p.AddConfigChange(tt.change)
+			if len(p.ConfigChanges) != tt.want {
+				t.Errorf("ConfigChanges = %v, want %d changes", p.ConfigChanges, tt.want)
+			}
+			if p.IsEmpty() != (tt.want == 0) {
+				t.Errorf("IsEmpty() = %v, want %v", p.IsEmpty(), tt.want == 0)
+			}
+		})
+	}
+}
+
+func testPlan() *Plan {
+	p := &Plan{}
+	ctx := WithPlan(context.Background(), p)
+	FromContext(ctx).AddRadarr(RadarrAdd{TmdbId: "348", Title: "Alien", Year: 1979, RootFolderPath: "/data/movies", Rule: "default"})
+	FromContext(ctx).AddSonarr(SonarrAdd{TmdbId: "87108", TvdbId: "360893", Title: "Chernobyl", Year: 2019, RootFolderPath: "/data/tv", Monitor: "all"})
+	FromContext(ctx).AddCollectionChange(CollectionChange{CollectionId: "collection", Action: ActionRemove, ItemId: "alien", Name: "Alien", Reason: "watched"})
+	FromContext(ctx).AddConfigChange(ConfigChange{User: "someone", Field: "User", New: "added"})
+	return p
+}
+
+func TestWriteTable(t *testing.T) {
+	var out bytes.Buffer
+	if err := testPlan().WriteTable(&out); err != nil {
+		t.Fatalf("WriteTable() returned error: %v", err)
+	}
+
+	want := `RADARR ADDS (1)
+TMDB  TITLE  YEAR  ROOT FOLDER   RULE
+348   Alien  1979  /data/movies  default
+
+SONARR ADDS (1)
+TMDB   TVDB    TITLE      YEAR  ROOT FOLDER  MONITOR
+87108  360893  Chernobyl  2019  /data/tv     all
+
+COLLECTION CHANGES (1)
+COLLECTION  ACTION  ITEM   NAME   REASON
+collection  remove  alien  Alien  watched
+
+CONFIG CHANGES (1)
+USER     FIELD  OLD  NEW
+someone  User        added
+`
+	if out.String() != want {
+		t.Errorf("WriteTable() = \n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	p := testPlan()
+	var out bytes.Buffer
+	if err := p.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON() returned error: %v", err)
+	}
+
+	var got Plan
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("WriteJSON() wrote invalid JSON %s: %v", out.String(), err)
+	}
+	if !reflect.DeepEqual(got.RadarrAdds, p.RadarrAdds) || !reflect.DeepEqual(got.SonarrAdds, p.SonarrAdds) || !reflect.DeepEqual(got.CollectionChanges, p.CollectionChanges) || !reflect.DeepEqual(got.ConfigChanges, p.ConfigChanges) {
+		t.Errorf("WriteJSON() = %s, want the changes of the plan", out.String())
+	}
+}
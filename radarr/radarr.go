@@ -1,19 +1,58 @@
 package radarr
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"slices"
+	"strings"
 
 	"diikstra.fr/letterboxd-jellyfin-go/config"
 
 	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
 )
 
-const RadarrUrl = "http://localhost:7878/api/v3/"
+var (
+	// ErrMovieNotFound is returned when the Radarr lookup has no result.
+	ErrMovieNotFound = errors.New("movie not found by Radarr")
+	// ErrRadarrRejected is returned when Radarr refuses to add a movie, the
+	// wrapped fetch.StatusError holds the reason.
+	ErrRadarrRejected  = errors.New("movie rejected by Radarr")
+	ErrInvalidResponse = errors.New("invalid Radarr response")
+)
+
+// Client talks to the Radarr v3 API of the configured server.
+type Client struct {
+	Fetcher          f.FetcherClient
+	Url              string
+	ApiKey           string
+	QualityProfile   string
+	QualityProfileId int
+	RootPaths        map[string]string
+	Rules            []config.RadarrRule
+	// Tags added by SendTmdbIDsToRadarr callers, checked by ResolveSettings.
+	ExtraTags []string
+
+	// Names of the quality profiles and tags resolved by ResolveSettings.
+	qualityProfileIds map[string]int
+	tagIds            map[string]int
+}
+
+func NewClient(fetcher f.FetcherClient, conf *config.Configuration) *Client {
+	return &Client{
+		Fetcher:          fetcher,
+		Url:              strings.TrimSuffix(conf.RadarrUrl, "/") + "/",
+		ApiKey:           conf.RadarrApiKey,
+		QualityProfile:   conf.RadarrQualityProfile,
+		QualityProfileId: conf.RadarrQualityProfileId,
+		RootPaths:        conf.RadarrRootPaths,
+		Rules:            conf.RadarrRules,
+		ExtraTags:        conf.ListRadarrTags(),
+	}
+}
 
 type RadarrState string
 
@@ -22,12 +61,20 @@ type MovieLookupFile struct {
 }
 
 type RadarrMovieLookupResp struct {
+	Id        int             `json:"id"`
 	MovieFile MovieLookupFile `json:"movieFile"`
 	Monitored bool            `json:"monitored"`
 	Title     string          `json:"title"`
 	TmdbId    int             `json:"tmdbId"`
+	ImdbId    string          `json:"imdbId"`
 	Year      int             `json:"year"`
 	Genres    []string        `json:"genres"`
+	Runtime   int             `json:"runtime"`
+
+	OriginalLanguage struct {
+		Name string `json:"name"`
+	} `json:"originalLanguage"`
+	Certification string `json:"certification"`
 }
 
 type RadarrStatus struct {
@@ -35,17 +82,27 @@ type RadarrStatus struct {
 	Monitored      bool
 	Title          string
 	TmdbId         string
+	ImdbId         string
 	ProductionYear int
 	IsAnimation    bool
+	Genres         []string
+	// Runtime in minutes, 0 when unknown.
+	Runtime          int
+	OriginalLanguage string
+	Certification    string
+	// InLibrary is true when the movie was already added to Radarr.
+	InLibrary bool
+	// Added is true when SendTmdbIDsToRadarr added the movie to Radarr.
+	Added bool
 }
 
-func GetRadarrState(client f.FetcherClient, tmdbId string) (RadarrStatus, error) {
-	body, err := client.FetchData(f.FetcherParams{
+func (rc *Client) GetRadarrState(ctx context.Context, tmdbId string) (RadarrStatus, error) {
+	body, err := rc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
 		Method: "GET",
-		Url:    RadarrUrl + "movie/lookup",
+		Url:    rc.Url + "movie/lookup",
 		Body:   nil,
 		Headers: f.Header{
-			"X-Api-Key": os.Getenv("RADARR_API_KEY"),
+			"X-Api-Key": rc.ApiKey,
 		},
 		Params: f.Param{
 			"term": "tmdb:" + tmdbId,
@@ -53,20 +110,16 @@ func GetRadarrState(client f.FetcherClient, tmdbId string) (RadarrStatus, error)
 	})
 
 	if err != nil {
-		log.Printf("Failed to get movie from Radarr: %v", err)
-		return RadarrStatus{}, err
+		return RadarrStatus{}, fmt.Errorf("failed to get movie tmdb:%s from Radarr: %w", tmdbId, err)
 	}
 
 	parsedBody := []RadarrMovieLookupResp{}
-	err = json.Unmarshal(body, &parsedBody)
-	if err != nil {
-		log.Println("Failed to parse JSON.")
-		panic(err)
+	if err := json.Unmarshal(body, &parsedBody); err != nil {
+		return RadarrStatus{}, fmt.Errorf("%w: lookup of tmdb:%s: %w", ErrInvalidResponse, tmdbId, err)
 	}
 
 	if len(parsedBody) == 0 {
-		log.Printf("TMDB id %s return empty state\n", tmdbId)
-		return RadarrStatus{}, errors.New("return empty state")
+		return RadarrStatus{}, fmt.Errorf("%w: tmdb:%s", ErrMovieNotFound, tmdbId)
 	}
 
 	return RadarrStatus{
@@ -74,8 +127,15 @@ func GetRadarrState(client f.FetcherClient, tmdbId string) (RadarrStatus, error)
 		Monitored:      parsedBody[0].Monitored,
 		Title:          parsedBody[0].Title,
 		TmdbId:         fmt.Sprint(parsedBody[0].TmdbId),
+		ImdbId:         parsedBody[0].ImdbId,
 		ProductionYear: parsedBody[0].Year,
 		IsAnimation:    slices.Contains(parsedBody[0].Genres, "Animation"),
+		Genres:         parsedBody[0].Genres,
+		Runtime:        parsedBody[0].Runtime,
+		InLibrary:      parsedBody[0].Id != 0,
+
+		OriginalLanguage: parsedBody[0].OriginalLanguage.Name,
+		Certification:    parsedBody[0].Certification,
 	}, nil
 }
 
@@ -84,59 +144,99 @@ type RadarrAddBodyAddOptions struct {
 }
 
 type RadarrAddBody struct {
-	TmdbId           string                  `json:"tmdbId,omitempty"`
-	Title            string                  `json:"title,omitempty"`
-	Year             int                     `json:"year,omitempty"`
-	QualityProfileId int                     `json:"qualityProfileId,omitempty"`
-	Monitored        bool                    `json:"monitored,omitempty"`
-	RootFolderPath   string                  `json:"rootFolderPath,omitempty"`
-	AddOptions       RadarrAddBodyAddOptions `json:"addOptions,omitempty"`
+	TmdbId              string                  `json:"tmdbId,omitempty"`
+	Title               string                  `json:"title,omitempty"`
+	Year                int                     `json:"year,omitempty"`
+	QualityProfileId    int                     `json:"qualityProfileId,omitempty"`
+	Monitored           bool                    `json:"monitored,omitempty"`
+	RootFolderPath      string                  `json:"rootFolderPath,omitempty"`
+	Tags                []int                   `json:"tags,omitempty"`
+	MinimumAvailability string                  `json:"minimumAvailability,omitempty"`
+	AddOptions          RadarrAddBodyAddOptions `json:"addOptions,omitempty"`
 }
 
-func AddToRadarrDownload(client f.FetcherClient, movie RadarrStatus, conf *config.Configuration) {
-	rootFolderPath := conf.RadarrRootPaths["movies"]
-	if movie.IsAnimation {
-		rootFolderPath = conf.RadarrRootPaths["anime_movies"]
+// AddToRadarrDownload adds the movie requested by the given Letterboxd user,
+// routed by the first matching rule. The given tags are added to the ones of
+// the rule, they must be part of ExtraTags.
+func (rc *Client) AddToRadarrDownload(ctx context.Context, movie RadarrStatus, requestedBy string, tags ...string) error {
+	routing := rc.Route(movie, requestedBy)
+	for _, label := range tags {
+		if id, ok := rc.tagIds[strings.ToLower(label)]; ok && !slices.Contains(routing.TagIds, id) {
+			routing.TagIds = append(routing.TagIds, id)
+		}
 	}
 
 	reqBody := RadarrAddBody{
-		TmdbId:           movie.TmdbId,
-		Title:            movie.Title,
-		Year:             movie.ProductionYear,
-		QualityProfileId: 11,
-		Monitored:        true,
-		RootFolderPath:   rootFolderPath,
+		TmdbId:              movie.TmdbId,
+		Title:               movie.Title,
+		Year:                movie.ProductionYear,
+		QualityProfileId:    routing.QualityProfileId,
+		Monitored:           true,
+		RootFolderPath:      routing.RootFolderPath,
+		Tags:                routing.TagIds,
+		MinimumAvailability: routing.MinimumAvailability,
 		AddOptions: RadarrAddBodyAddOptions{
 			SearchForMovie: true,
 		},
 	}
 
-	client.FetchData(f.FetcherParams{
+	plan.FromContext(ctx).AddRadarr(plan.RadarrAdd{
+		TmdbId:         movie.TmdbId,
+		Title:          movie.Title,
+		Year:           movie.ProductionYear,
+		RootFolderPath: routing.RootFolderPath,
+		Rule:           routing.Rule,
+	})
+
+	_, err := rc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
 		Method: "POST",
-		Url:    RadarrUrl + "movie",
+		Url:    rc.Url + "movie",
 		Body:   reqBody,
 		Headers: f.Header{
-			"X-Api-Key":    os.Getenv("RADARR_API_KEY"),
+			"X-Api-Key":    rc.ApiKey,
 			"Content-Type": "application/json",
 		},
 		Params:       f.Param{},
-		WantErrCodes: []int{201, 400},
+		WantErrCodes: []int{201},
 	})
+	if errors.Is(err, f.ErrUnexpectedStatus) {
+		return fmt.Errorf("%w: %s (tmdb:%s): %w", ErrRadarrRejected, movie.Title, movie.TmdbId, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add %s (tmdb:%s) to Radarr: %w", movie.Title, movie.TmdbId, err)
+	}
+
+	return nil
 }
 
-func SendTmdbIDsToRadarr(client f.FetcherClient, tmdbIds []string, conf *config.Configuration) []RadarrStatus {
+// SendTmdbIDsToRadarr adds the movies missing from Radarr and returns the
+// state of every movie found. Movies Radarr does not know are logged and
+// skipped, the other failures are joined in the returned error.
+func (rc *Client) SendTmdbIDsToRadarr(ctx context.Context, tmdbIds []string, requestedBy string, tags ...string) ([]RadarrStatus, error) {
 	var states []RadarrStatus
+	var errs []error
 
 	for _, tmdbId := range tmdbIds {
 		if tmdbId != "" {
-			state, err := GetRadarrState(client, tmdbId)
+			state, err := rc.GetRadarrState(ctx, tmdbId)
+			if errors.Is(err, ErrMovieNotFound) {
+				log.Println(err)
+				continue
+			}
 			if err != nil {
+				errs = append(errs, err)
 				continue
 			}
-			AddToRadarrDownload(client, state, conf)
+			if !state.InLibrary {
+				err := rc.AddToRadarrDownload(ctx, state, requestedBy, tags...)
+				if err != nil && !errors.Is(err, f.ErrDryRun) {
+					errs = append(errs, err)
+				}
+				state.Added = err == nil
+			}
 			states = append(states, state)
 		}
 	}
 
-	return states
+	return states, errors.Join(errs...)
 }
@@ -1,7 +1,9 @@
 package radarr
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os"
 	"path"
 	"path/filepath"
@@ -24,6 +26,10 @@ func (m *MockClient) FetchData(fp f.FetcherParams) ([]byte, error) {
 	return args.Get(0).([]byte), args.Error(1)
 }
 
+func (m *MockClient) FetchDataContext(ctx context.Context, fp f.FetcherParams) ([]byte, error) {
+	return m.FetchData(fp)
+}
+
 // Get info of the current directory of the executed file
 var (
 	_, b, _, _ = runtime.Caller(0)
@@ -54,6 +60,7 @@ func TestGetRadarrState(t *testing.T) {
 		Monitored: true,
 		Title:     "Alien",
 		TmdbId:    123,
+		ImdbId:    "tt0078748",
 		Year:      1979,
 		Genres:    []string{"Horror", "Science Fiction"},
 	}}
@@ -88,8 +95,10 @@ func TestGetRadarrState(t *testing.T) {
 				Monitored:      true,
 				Title:          "Alien",
 				TmdbId:         "123",
+				ImdbId:         "tt0078748",
 				ProductionYear: 1979,
 				IsAnimation:    false,
+				Genres:         []string{"Horror", "Science Fiction"},
 			},
 			clientResponse: byteTestData_1,
 		},
@@ -105,6 +114,7 @@ func TestGetRadarrState(t *testing.T) {
 				TmdbId:         "22794",
 				ProductionYear: 2009,
 				IsAnimation:    true,
+				Genres:         []string{"Animation", "Comedy", "Family"},
 			},
 			clientResponse: byteTestData_2,
 		},
@@ -113,7 +123,7 @@ func TestGetRadarrState(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			mockClient := new(MockClient)
 			mockClient.On("FetchData", mock.Anything).Return(tt.clientResponse, nil)
-			got, err := GetRadarrState(mockClient, tt.args.tmdbId)
+			got, err := (&Client{Fetcher: mockClient, Url: "http://radarr.test/api/v3/", ApiKey: "test"}).GetRadarrState(context.Background(), tt.args.tmdbId)
 			if err != nil {
 				t.Errorf("GetRadarrState() returned error: %v", err)
 			}
@@ -123,3 +133,88 @@ func TestGetRadarrState(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRadarrStateErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		clientResponse []byte
+		want           error
+	}{
+		{name: "Test unknown movie", clientResponse: []byte("[]"), want: ErrMovieNotFound},
+		{name: "Test invalid JSON", clientResponse: []byte("<html>"), want: ErrInvalidResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockClient)
+			mockClient.On("FetchData", mock.Anything).Return(tt.clientResponse, nil)
+			_, err := (&Client{Fetcher: mockClient, Url: "http://radarr.test/api/v3/"}).GetRadarrState(context.Background(), "1")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("GetRadarrState() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSettings(t *testing.T) {
+	profiles, _ := json.Marshal([]QualityProfile{{Id: 4, Name: "HD-1080p"}, {Id: 11, Name: "Ultra-HD"}})
+	folders, _ := json.Marshal([]RootFolder{
+		{Id: 1, Path: "/data/complete/movies/", Accessible: true},
+		{Id: 2, Path: "/data/complete/anime_movies", Accessible: true},
+	})
+
+	tests := []struct {
+		name             string
+		qualityProfile   string
+		qualityProfileId int
+		rootPaths        map[string]string
+		wantProfileId    int
+		wantErr          bool
+	}{
+		{
+			name:           "Test profile resolved by name",
+			qualityProfile: "hd-1080p",
+			rootPaths:      map[string]string{"movies": "/data/complete/movies", "anime_movies": "/data/complete/anime_movies"},
+			wantProfileId:  4,
+		},
+		{
+			name:             "Test profile kept by id",
+			qualityProfileId: 11,
+			rootPaths:        map[string]string{"movies": "/data/complete/movies", "anime_movies": "/data/complete/anime_movies", "series": "/data/complete/tv"},
+			wantProfileId:    11,
+		},
+		{
+			name:           "Test unknown profile",
+			qualityProfile: "SD",
+			rootPaths:      map[string]string{"movies": "/data/complete/movies", "anime_movies": "/data/complete/anime_movies"},
+			wantErr:        true,
+		},
+		{
+			name:           "Test unknown root folder",
+			qualityProfile: "HD-1080p",
+			rootPaths:      map[string]string{"movies": "/data/movies", "anime_movies": "/data/complete/anime_movies"},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockClient)
+			mockClient.On("FetchData", "http://radarr.test/api/v3/qualityprofile").Return(profiles, nil)
+			mockClient.On("FetchData", "http://radarr.test/api/v3/rootfolder").Return(folders, nil)
+
+			client := &Client{
+				Fetcher:          mockClient,
+				Url:              "http://radarr.test/api/v3/",
+				QualityProfile:   tt.qualityProfile,
+				QualityProfileId: tt.qualityProfileId,
+				RootPaths:        tt.rootPaths,
+			}
+			err := client.ResolveSettings(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && client.QualityProfileId != tt.wantProfileId {
+				t.Errorf("ResolveSettings() set profile id %d, want %d", client.QualityProfileId, tt.wantProfileId)
+			}
+		})
+	}
+}
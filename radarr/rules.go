@@ -0,0 +1,108 @@
+package radarr
+
+import (
+	"strings"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+)
+
+// Routing is where and how a movie is added to Radarr.
+type Routing struct {
+	Rule                string
+	RootFolderPath      string
+	QualityProfileId    int
+	TagIds              []int
+	MinimumAvailability string
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func inRange(value int, min int, max int) bool {
+	return (min == 0 || value >= min) && (max == 0 || value <= max)
+}
+
+func ruleMatches(match config.RadarrRuleMatch, movie RadarrStatus, requestedBy string) bool {
+	if len(match.Genres) > 0 {
+		found := false
+		for _, genre := range movie.Genres {
+			if containsFold(match.Genres, genre) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(match.OriginalLanguages) > 0 && !containsFold(match.OriginalLanguages, movie.OriginalLanguage) {
+		return false
+	}
+	if len(match.Certifications) > 0 && !containsFold(match.Certifications, movie.Certification) {
+		return false
+	}
+	if len(match.Users) > 0 && !containsFold(match.Users, requestedBy) {
+		return false
+	}
+	if !inRange(movie.ProductionYear, match.YearMin, match.YearMax) {
+		return false
+	}
+	// An unknown runtime never satisfies a runtime bound.
+	if (match.RuntimeMin != 0 || match.RuntimeMax != 0) &&
+		(movie.Runtime == 0 || !inRange(movie.Runtime, match.RuntimeMin, match.RuntimeMax)) {
+		return false
+	}
+	return true
+}
+
+// rootFolderPath accepts a key of RadarrRootPaths or a path.
+func (rc *Client) rootFolderPath(value string) string {
+	if path, ok := rc.RootPaths[value]; ok {
+		return path
+	}
+	return value
+}
+
+// Route evaluates the rules in order for the movie requested by the given
+// Letterboxd user. The first matching rule decides, the fields it leaves empty
+// (or every field when no rule matches) use the default routing: the anime
+// root folder for animation movies and the configured quality profile.
+func (rc *Client) Route(movie RadarrStatus, requestedBy string) Routing {
+	routing := Routing{
+		Rule:             "default",
+		RootFolderPath:   rc.RootPaths["movies"],
+		QualityProfileId: rc.QualityProfileId,
+	}
+	if movie.IsAnimation {
+		routing.RootFolderPath = rc.RootPaths["anime_movies"]
+	}
+
+	for _, rule := range rc.Rules {
+		if !ruleMatches(rule.Match, movie, requestedBy) {
+			continue
+		}
+
+		routing.Rule = rule.Name
+		if rule.RootFolder != "" {
+			routing.RootFolderPath = rc.rootFolderPath(rule.RootFolder)
+		}
+		if id, ok := rc.qualityProfileIds[strings.ToLower(rule.QualityProfile)]; ok {
+			routing.QualityProfileId = id
+		}
+		for _, label := range rule.Tags {
+			if id, ok := rc.tagIds[strings.ToLower(label)]; ok {
+				routing.TagIds = append(routing.TagIds, id)
+			}
+		}
+		routing.MinimumAvailability = rule.MinimumAvailability
+		break
+	}
+
+	return routing
+}
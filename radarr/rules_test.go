@@ -0,0 +1,79 @@
+package radarr
+
+import (
+	"reflect"
+	"testing"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+)
+
+func TestRoute(t *testing.T) {
+	client := &Client{
+		QualityProfileId: 11,
+		RootPaths: map[string]string{
+			"movies":       "/data/complete/movies",
+			"anime_movies": "/data/complete/anime_movies",
+		},
+		Rules: []config.RadarrRule{{
+			Name:                "french classics",
+			Match:               config.RadarrRuleMatch{OriginalLanguages: []string{"French"}, YearMax: 1980},
+			RootFolder:          "/data/complete/classics",
+			QualityProfile:      "HD-1080p",
+			Tags:                []string{"classics"},
+			MinimumAvailability: "released",
+		}, {
+			Name:       "short animation for kids",
+			Match:      config.RadarrRuleMatch{Genres: []string{"animation"}, RuntimeMax: 60, Users: []string{"Mathis_V"}},
+			RootFolder: "anime_movies",
+		}, {
+			Name:           "long movies",
+			Match:          config.RadarrRuleMatch{RuntimeMin: 180},
+			QualityProfile: "SD",
+		}},
+		qualityProfileIds: map[string]int{"hd-1080p": 4, "sd": 1},
+		tagIds:            map[string]int{"classics": 7},
+	}
+
+	tests := []struct {
+		name        string
+		movie       RadarrStatus
+		requestedBy string
+		want        Routing
+	}{
+		{
+			name:  "Test first matching rule wins",
+			movie: RadarrStatus{OriginalLanguage: "French", ProductionYear: 1959, Runtime: 200},
+			want: Routing{
+				Rule:                "french classics",
+				RootFolderPath:      "/data/complete/classics",
+				QualityProfileId:    4,
+				TagIds:              []int{7},
+				MinimumAvailability: "released",
+			},
+		},
+		{
+			name:        "Test requesting user and root path key",
+			movie:       RadarrStatus{Genres: []string{"Animation"}, IsAnimation: true, Runtime: 45},
+			requestedBy: "mathis_v",
+			want:        Routing{Rule: "short animation for kids", RootFolderPath: "/data/complete/anime_movies", QualityProfileId: 11},
+		},
+		{
+			name:        "Test unknown runtime does not match runtime bounds",
+			movie:       RadarrStatus{Genres: []string{"Animation"}, IsAnimation: true},
+			requestedBy: "Mathis_V",
+			want:        Routing{Rule: "default", RootFolderPath: "/data/complete/anime_movies", QualityProfileId: 11},
+		},
+		{
+			name:  "Test empty rule fields keep default routing",
+			movie: RadarrStatus{OriginalLanguage: "English", ProductionYear: 2019, Runtime: 209},
+			want:  Routing{Rule: "long movies", RootFolderPath: "/data/complete/movies", QualityProfileId: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := client.Route(tt.movie, tt.requestedBy); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Route() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package radarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+)
+
+var ErrInvalidSettings = errors.New("invalid radarr settings")
+
+// Keys of RadarrRootPaths used for movies, the other ones belong to series.
+var movieRootPathKeys = []string{"movies", "anime_movies"}
+
+type QualityProfile struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type RootFolder struct {
+	Id         int    `json:"id"`
+	Path       string `json:"path"`
+	Accessible bool   `json:"accessible"`
+}
+
+func (rc *Client) getList(ctx context.Context, endpoint string, list any) error {
+	body, err := rc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "GET",
+		Url:    rc.Url + endpoint,
+		Body:   nil,
+		Headers: f.Header{
+			"X-Api-Key": rc.ApiKey,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get %s from Radarr: %w", endpoint, err)
+	}
+
+	if err := json.Unmarshal(body, list); err != nil {
+		return fmt.Errorf("failed to parse %s from Radarr: %w", endpoint, err)
+	}
+	return nil
+}
+
+func (rc *Client) GetQualityProfiles(ctx context.Context) ([]QualityProfile, error) {
+	var profiles []QualityProfile
+	err := rc.getList(ctx, "qualityprofile", &profiles)
+	return profiles, err
+}
+
+func (rc *Client) GetRootFolders(ctx context.Context) ([]RootFolder, error) {
+	var folders []RootFolder
+	err := rc.getList(ctx, "rootfolder", &folders)
+	return folders, err
+}
+
+func samePath(a string, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+type Tag struct {
+	Id    int    `json:"id"`
+	Label string `json:"label"`
+}
+
+func (rc *Client) GetTags(ctx context.Context) ([]Tag, error) {
+	var tags []Tag
+	err := rc.getList(ctx, "tag", &tags)
+	return tags, err
+}
+
+var minimumAvailabilities = []string{"announced", "inCinemas", "released"}
+
+// ResolveSettings checks the configuration against the Radarr server before
+// anything is added: quality profile names are resolved to their ids (or the
+// configured id is checked to exist), every root path must be a root folder
+// of Radarr and the tags used by the rules and lists must exist. All problems
+// are reported in a single error.
+func (rc *Client) ResolveSettings(ctx context.Context) error {
+	profiles, err := rc.GetQualityProfiles(ctx)
+	if err != nil {
+		return err
+	}
+	folders, err := rc.GetRootFolders(ctx)
+	if err != nil {
+		return err
+	}
+	var tags []Tag
+	if rc.usesTags() {
+		if tags, err = rc.GetTags(ctx); err != nil {
+			return err
+		}
+	}
+
+	var problems []string
+
+	var profileNames []string
+	rc.qualityProfileIds = make(map[string]int)
+	found := false
+	for _, profile := range profiles {
+		profileNames = append(profileNames, profile.Name)
+		rc.qualityProfileIds[strings.ToLower(profile.Name)] = profile.Id
+		if (rc.QualityProfile != "" && strings.EqualFold(profile.Name, rc.QualityProfile)) ||
+			(rc.QualityProfile == "" && profile.Id == rc.QualityProfileId) {
+			rc.QualityProfileId = profile.Id
+			found = true
+		}
+	}
+	if !found {
+		wanted := rc.QualityProfile
+		if wanted == "" {
+			wanted = fmt.Sprintf("id %d", rc.QualityProfileId)
+		}
+		problems = append(problems, fmt.Sprintf("quality profile %q does not exist, available: %s", wanted, strings.Join(profileNames, ", ")))
+	}
+
+	var folderPaths []string
+	for _, folder := range folders {
+		folderPaths = append(folderPaths, folder.Path)
+	}
+	checkFolder := func(path string, description string) {
+		for _, folder := range folders {
+			if samePath(folder.Path, path) {
+				if !folder.Accessible {
+					problems = append(problems, fmt.Sprintf("root folder %q (%s) is not accessible by Radarr", path, description))
+				}
+				return
+			}
+		}
+		problems = append(problems, fmt.Sprintf("root folder %q (%s) does not exist, available: %s", path, description, strings.Join(folderPaths, ", ")))
+	}
+
+	for _, key := range movieRootPathKeys {
+		path, ok := rc.RootPaths[key]
+		if !ok || path == "" {
+			problems = append(problems, fmt.Sprintf("no root path configured for %q", key))
+			continue
+		}
+		checkFolder(path, key)
+	}
+
+	rc.tagIds = make(map[string]int)
+	var tagLabels []string
+	for _, tag := range tags {
+		rc.tagIds[strings.ToLower(tag.Label)] = tag.Id
+		tagLabels = append(tagLabels, tag.Label)
+	}
+
+	for _, rule := range rc.Rules {
+		if rule.RootFolder != "" {
+			checkFolder(rc.rootFolderPath(rule.RootFolder), "rule "+rule.Name)
+		}
+		if _, ok := rc.qualityProfileIds[strings.ToLower(rule.QualityProfile)]; rule.QualityProfile != "" && !ok {
+			problems = append(problems, fmt.Sprintf("quality profile %q of rule %s does not exist, available: %s", rule.QualityProfile, rule.Name, strings.Join(profileNames, ", ")))
+		}
+		for _, label := range rule.Tags {
+			if _, ok := rc.tagIds[strings.ToLower(label)]; !ok {
+				problems = append(problems, fmt.Sprintf("tag %q of rule %s does not exist, available: %s", label, rule.Name, strings.Join(tagLabels, ", ")))
+			}
+		}
+		if rule.MinimumAvailability != "" && !slices.Contains(minimumAvailabilities, rule.MinimumAvailability) {
+			problems = append(problems, fmt.Sprintf("minimum availability %q of rule %s is invalid, expected one of: %s", rule.MinimumAvailability, rule.Name, strings.Join(minimumAvailabilities, ", ")))
+		}
+	}
+
+	for _, label := range rc.ExtraTags {
+		if _, ok := rc.tagIds[strings.ToLower(label)]; !ok {
+			problems = append(problems, fmt.Sprintf("tag %q of a Letterboxd list does not exist, available: %s", label, strings.Join(tagLabels, ", ")))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n  - %s", ErrInvalidSettings, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func (rc *Client) usesTags() bool {
+	if len(rc.ExtraTags) > 0 {
+		return true
+	}
+	for _, rule := range rc.Rules {
+		if len(rule.Tags) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package schedule
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule gives the activation times of a recurring job.
+type Schedule interface {
+	// Next returns the first activation time after t.
+	Next(t time.Time) time.Time
+}
+
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// Every returns a schedule activating every d, d must be positive.
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+// Parse reads a standard cron expression of 5 fields ("*/30 * * * *") or a
+// descriptor like "@daily" or "@every 1h30m", in the local time zone.
+func Parse(expression string) (Schedule, error) {
+	return cron.ParseStandard(expression)
+}
+
+// Jitter delays t by a random duration up to maxDelay.
+func Jitter(t time.Time, maxDelay time.Duration) time.Time {
+	if maxDelay <= 0 {
+		return t
+	}
+	return t.Add(rand.N(maxDelay))
+}
@@ -0,0 +1,50 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	from := time.Date(2024, 5, 1, 12, 10, 0, 0, time.Local)
+
+	tests := []struct {
+		name       string
+		expression string
+		want       time.Time
+		wantErr    bool
+	}{
+		{name: "Test every 30 minutes", expression: "*/30 * * * *", want: time.Date(2024, 5, 1, 12, 30, 0, 0, time.Local)},
+		{name: "Test daily", expression: "@daily", want: time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local)},
+		{name: "Test every descriptor", expression: "@every 1h30m", want: from.Add(90 * time.Minute)},
+		{name: "Test invalid", expression: "every day", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := Parse(tt.expression)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := Jitter(from, 0); !got.Equal(from) {
+		t.Errorf("Jitter() = %v, want %v", got, from)
+	}
+	for range 100 {
+		got := Jitter(Every(time.Hour).Next(from), time.Minute)
+		if got.Before(from.Add(time.Hour)) || !got.Before(from.Add(time.Hour+time.Minute)) {
+			t.Fatalf("Jitter() = %v, want within a minute after %v", got, from.Add(time.Hour))
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+	lt "diikstra.fr/letterboxd-jellyfin-go/letterboxd"
+	"diikstra.fr/letterboxd-jellyfin-go/schedule"
+	"diikstra.fr/letterboxd-jellyfin-go/state"
+)
+
+// Time given to the running syncs to finish once serve is asked to stop,
+// before their requests are cancelled.
+const serveShutdownGracePeriod = 20 * time.Second
+
+// The Jellyfin library is reloaded before a sync when it is older than this.
+const libraryMaxAge = 5 * time.Minute
+
+var ErrNoSchedule = errors.New("serve needs SyncIntervalMinutes or SyncSchedule")
+
+// server syncs the users on their schedule, reusing the clients and caches of
+// the syncer between syncs.
+type server struct {
+	syncer    *syncer
+	store     *state.Store
+	slugCache *lt.SlugCache
+	// Incremental syncs, and the forced full syncs when fullSync is not nil.
+	sync     schedule.Schedule
+	fullSync schedule.Schedule
+	jitter   time.Duration
+	// Limits the number of users synced at once to ConcurrentUsers.
+	slots chan struct{}
+	// nil when WebhookListenAddress is empty.
+	webhook *http.Server
+}
+
+func newServer(s *syncer, store *state.Store, slugCache *lt.SlugCache) (*server, error) {
+	conf := s.conf
+	srv := &server{
+		syncer:    s,
+		store:     store,
+		slugCache: slugCache,
+		jitter:    time.Duration(conf.ScheduleJitterSeconds) * time.Second,
+		slots:     make(chan struct{}, max(conf.ConcurrentUsers, 1)),
+	}
+
+	var err error
+	switch {
+	case conf.SyncSchedule != "":
+		srv.sync, err = schedule.Parse(conf.SyncSchedule)
+	case conf.SyncIntervalMinutes > 0:
+		srv.sync = schedule.Every(time.Duration(conf.SyncIntervalMinutes) * time.Minute)
+	default:
+		err = ErrNoSchedule
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.FullSyncSchedule != "" {
+		srv.fullSync, err = schedule.Parse(conf.FullSyncSchedule)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.WebhookListenAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("POST "+webhookPath, &webhookHandler{syncer: s, store: store, secret: conf.WebhookSecret})
+		srv.webhook = &http.Server{
+			Addr:              conf.WebhookListenAddress,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return srv, nil
+}
+
+// serve runs the schedule of every user and the webhook endpoint until ctx
+// is done. The running syncs and notifications are then given
+// serveShutdownGracePeriod to finish.
+func (srv *server) serve(ctx context.Context) error {
+	// The syncs outlive ctx by the grace period.
+	syncCtx, cancelSyncs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelSyncs()
+
+	if srv.webhook != nil {
+		listener, err := net.Listen("tcp", srv.webhook.Addr)
+		if err != nil {
+			return err
+		}
+		srv.webhook.BaseContext = func(net.Listener) context.Context {
+			return syncCtx
+		}
+		go func() {
+			err := srv.webhook.Serve(listener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Webhook endpoint stopped: %v", err)
+			}
+		}()
+		log.Printf("Listening for Jellyfin webhooks on %s%s", listener.Addr(), webhookPath)
+	}
+
+	var wg sync.WaitGroup
+	for index := range srv.syncer.conf.Users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.runUser(ctx, syncCtx, &srv.syncer.conf.Users[index])
+		}()
+	}
+	log.Printf("Serving %d users", len(srv.syncer.conf.Users))
+
+	<-ctx.Done()
+	log.Printf("Stopping, waiting up to %s for the running syncs", serveShutdownGracePeriod)
+	if srv.webhook != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Refuses new notifications and waits for the ones being handled,
+			// which are cancelled with the syncs.
+			if err := srv.webhook.Shutdown(syncCtx); err != nil {
+				srv.webhook.Close()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(serveShutdownGracePeriod):
+		log.Println("Cancelling the running syncs")
+		cancelSyncs()
+		<-done
+	}
+	return nil
+}
+
+// next returns the time of the next sync of a user and whether it is a
+// forced full sync.
+func (srv *server) next(now time.Time) (time.Time, bool) {
+	at := srv.sync.Next(now)
+	if srv.fullSync != nil {
+		if fullAt := srv.fullSync.Next(now); !fullAt.After(at) {
+			return schedule.Jitter(fullAt, srv.jitter), true
+		}
+	}
+	return schedule.Jitter(at, srv.jitter), false
+}
+
+// runUser syncs the user at each activation of the schedule until ctx is
+// done, the syncs themselves run with syncCtx.
+func (srv *server) runUser(ctx context.Context, syncCtx context.Context, user *config.UserData) {
+	for {
+		at, full := srv.next(time.Now())
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case srv.slots <- struct{}{}:
+		}
+		srv.syncUser(syncCtx, user, full)
+		<-srv.slots
+	}
+}
+
+// syncUser runs one sync of the user within the run budget, then saves the
+// state and the slug cache so that a crash loses at most this sync.
+func (srv *server) syncUser(ctx context.Context, user *config.UserData, full bool) {
+	conf := srv.syncer.conf
+	if conf.RunBudgetMinutes > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(conf.RunBudgetMinutes)*time.Minute)
+		defer cancel()
+	}
+
+	command := "serve sync"
+	if full {
+		command = "serve full-sync"
+	}
+	start := time.Now()
+
+	err := srv.syncer.refreshLibrary(ctx, libraryMaxAge)
+	if err == nil {
+		err = srv.syncer.syncUser(ctx, user, full)
+	}
+
+	run := state.Run{Command: command, StartedAt: start, Duration: time.Since(start), Users: 1}
+	if err != nil {
+		run.Failed = 1
+		log.Printf("Failed to sync %s (%s): %v", user.Username, errorKind(err), err)
+	} else {
+		log.Printf("Synced %s in %s", user.Username, run.Duration.Round(time.Second))
+	}
+	srv.store.AddRun(run)
+
+	if err := srv.store.Save(); err != nil {
+		log.Printf("Failed to save state: %v", err)
+	}
+	if err := srv.slugCache.Save(); err != nil {
+		log.Printf("Failed to save slug cache: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/schedule"
+)
+
+func TestServerNext(t *testing.T) {
+	now := time.Date(2024, 5, 6, 10, 30, 0, 0, time.UTC)
+	hourly, _ := schedule.Parse("0 * * * *")
+	nightly, _ := schedule.Parse("0 3 * * *")
+	atEleven, _ := schedule.Parse("0 11 * * *")
+
+	tests := []struct {
+		name     string
+		sync     schedule.Schedule
+		fullSync schedule.Schedule
+		jitter   time.Duration
+		want     time.Time
+		wantFull bool
+	}{
+		{
+			name: "Test incremental sync only",
+			sync: hourly,
+			want: time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "Test interval",
+			sync: schedule.Every(15 * time.Minute),
+			want: now.Add(15 * time.Minute),
+		},
+		{
+			name:     "Test incremental sync before the full sync",
+			sync:     hourly,
+			fullSync: nightly,
+			want:     time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Test full sync at the same time",
+			sync:     hourly,
+			fullSync: atEleven,
+			want:     time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+			wantFull: true,
+		},
+		{
+			name:     "Test full sync before the incremental sync",
+			sync:     schedule.Every(24 * time.Hour),
+			fullSync: atEleven,
+			want:     time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+			wantFull: true,
+		},
+		{
+			name:   "Test jitter",
+			sync:   hourly,
+			jitter: 10 * time.Minute,
+			want:   time.Date(2024, 5, 6, 11, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &server{sync: tt.sync, fullSync: tt.fullSync, jitter: tt.jitter}
+
+			got, full := srv.next(now)
+			if full != tt.wantFull {
+				t.Errorf("next() full = %v, want %v", full, tt.wantFull)
+			}
+			if got.Before(tt.want) || got.After(tt.want.Add(tt.jitter)) {
+				t.Errorf("next() = %s, want %s plus up to %s", got, tt.want, tt.jitter)
+			}
+		})
+	}
+}
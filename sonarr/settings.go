@@ -0,0 +1,129 @@
+package sonarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+)
+
+var ErrInvalidSettings = errors.New("invalid sonarr settings")
+
+// Keys of RadarrRootPaths used for series.
+var seriesRootPathKeys = []string{"series", "anime_series"}
+
+var monitorOptions = []string{"all", "future", "missing", "existing", "recent", "pilot", "firstSeason", "lastSeason", "monitorSpecials", "unmonitorSpecials", "none"}
+
+type QualityProfile struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type RootFolder struct {
+	Id         int    `json:"id"`
+	Path       string `json:"path"`
+	Accessible bool   `json:"accessible"`
+}
+
+func (sc *Client) getList(ctx context.Context, endpoint string, list any) error {
+	body, err := sc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "GET",
+		Url:    sc.Url + endpoint,
+		Body:   nil,
+		Headers: f.Header{
+			"X-Api-Key": sc.ApiKey,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get %s from Sonarr: %w", endpoint, err)
+	}
+
+	if err := json.Unmarshal(body, list); err != nil {
+		return fmt.Errorf("failed to parse %s from Sonarr: %w", endpoint, err)
+	}
+	return nil
+}
+
+func (sc *Client) GetQualityProfiles(ctx context.Context) ([]QualityProfile, error) {
+	var profiles []QualityProfile
+	err := sc.getList(ctx, "qualityprofile", &profiles)
+	return profiles, err
+}
+
+func (sc *Client) GetRootFolders(ctx context.Context) ([]RootFolder, error) {
+	var folders []RootFolder
+	err := sc.getList(ctx, "rootfolder", &folders)
+	return folders, err
+}
+
+// ResolveSettings checks the configuration against the Sonarr server before
+// anything is added, like radarr.Client.ResolveSettings does for Radarr.
+func (sc *Client) ResolveSettings(ctx context.Context) error {
+	profiles, err := sc.GetQualityProfiles(ctx)
+	if err != nil {
+		return err
+	}
+	folders, err := sc.GetRootFolders(ctx)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+
+	var profileNames []string
+	found := false
+	for _, profile := range profiles {
+		profileNames = append(profileNames, profile.Name)
+		if (sc.QualityProfile != "" && strings.EqualFold(profile.Name, sc.QualityProfile)) ||
+			(sc.QualityProfile == "" && profile.Id == sc.QualityProfileId) {
+			sc.QualityProfileId = profile.Id
+			found = true
+		}
+	}
+	if !found {
+		wanted := sc.QualityProfile
+		if wanted == "" {
+			wanted = fmt.Sprintf("id %d", sc.QualityProfileId)
+		}
+		problems = append(problems, fmt.Sprintf("quality profile %q does not exist, available: %s", wanted, strings.Join(profileNames, ", ")))
+	}
+
+	var folderPaths []string
+	for _, folder := range folders {
+		folderPaths = append(folderPaths, folder.Path)
+	}
+
+	for _, key := range seriesRootPathKeys {
+		path, ok := sc.RootPaths[key]
+		if !ok || path == "" {
+			problems = append(problems, fmt.Sprintf("no root path configured for %q in RadarrRootPaths", key))
+			continue
+		}
+
+		exists := false
+		for _, folder := range folders {
+			if strings.TrimSuffix(folder.Path, "/") == strings.TrimSuffix(path, "/") {
+				exists = true
+				if !folder.Accessible {
+					problems = append(problems, fmt.Sprintf("root folder %q (%s) is not accessible by Sonarr", path, key))
+				}
+			}
+		}
+		if !exists {
+			problems = append(problems, fmt.Sprintf("root folder %q (%s) does not exist, available: %s", path, key, strings.Join(folderPaths, ", ")))
+		}
+	}
+
+	if !slices.Contains(monitorOptions, sc.Monitor) {
+		problems = append(problems, fmt.Sprintf("monitor option %q is invalid, expected one of: %s", sc.Monitor, strings.Join(monitorOptions, ", ")))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n  - %s", ErrInvalidSettings, strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package sonarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	"diikstra.fr/letterboxd-jellyfin-go/plan"
+)
+
+var (
+	// ErrSeriesNotFound is returned when the Sonarr lookup has no result.
+	ErrSeriesNotFound = errors.New("series not found by Sonarr")
+	// ErrSonarrRejected is returned when Sonarr refuses to add a series, the
+	// wrapped fetch.StatusError holds the reason.
+	ErrSonarrRejected  = errors.New("series rejected by Sonarr")
+	ErrInvalidResponse = errors.New("invalid Sonarr response")
+	// ErrMissingTvdbId is returned for the series Sonarr found without a TVDB
+	// id, which it needs to add them.
+	ErrMissingTvdbId = errors.New("series without TVDB id")
+)
+
+// Client talks to the Sonarr v3 API of the configured server.
+type Client struct {
+	Fetcher          f.FetcherClient
+	Url              string
+	ApiKey           string
+	QualityProfile   string
+	QualityProfileId int
+	RootPaths        map[string]string
+	// Seasons monitored when a series is added, see monitorOptions.
+	Monitor string
+}
+
+// NewClient reads the series and anime_series root paths from
+// RadarrRootPaths, shared with the radarr package.
+func NewClient(fetcher f.FetcherClient, conf *config.Configuration) *Client {
+	monitor := conf.SonarrMonitor
+	if monitor == "" {
+		monitor = "all"
+	}
+
+	return &Client{
+		Fetcher:          fetcher,
+		Url:              strings.TrimSuffix(conf.SonarrUrl, "/") + "/",
+		ApiKey:           conf.SonarrApiKey,
+		QualityProfile:   conf.SonarrQualityProfile,
+		QualityProfileId: conf.SonarrQualityProfileId,
+		RootPaths:        conf.RadarrRootPaths,
+		Monitor:          monitor,
+	}
+}
+
+type SeriesStatistics struct {
+	EpisodeFileCount int `json:"episodeFileCount"`
+}
+
+type SonarrSeriesLookupResp struct {
+	Id         int              `json:"id"`
+	Title      string           `json:"title"`
+	TitleSlug  string           `json:"titleSlug"`
+	TvdbId     int              `json:"tvdbId"`
+	TmdbId     int              `json:"tmdbId"`
+	ImdbId     string           `json:"imdbId"`
+	Year       int              `json:"year"`
+	Genres     []string         `json:"genres"`
+	Monitored  bool             `json:"monitored"`
+	Statistics SeriesStatistics `json:"statistics"`
+}
+
+type SonarrStatus struct {
+	HasFile        bool
+	Monitored      bool
+	Title          string
+	TitleSlug      string
+	TmdbId         string
+	TvdbId         string
+	ImdbId         string
+	ProductionYear int
+	IsAnime        bool
+	IsAnimation    bool
+	// InLibrary is true when the series was already added to Sonarr.
+	InLibrary bool
+	// Added is true when SendSeriesToSonarr added the series to Sonarr.
+	Added bool
+}
+
+// isUnsupportedLookup reports whether Sonarr rejected the lookup term
+// rather than failed to answer.
+func isUnsupportedLookup(err error) bool {
+	var statusErr *f.StatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode == 400 || statusErr.StatusCode == 404)
+}
+
+func (sc *Client) lookup(ctx context.Context, term string) ([]SonarrSeriesLookupResp, error) {
+	body, err := sc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "GET",
+		Url:    sc.Url + "series/lookup",
+		Body:   nil,
+		Headers: f.Header{
+			"X-Api-Key": sc.ApiKey,
+		},
+		Params: f.Param{
+			"term": term,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	parsedBody := []SonarrSeriesLookupResp{}
+	if err := json.Unmarshal(body, &parsedBody); err != nil {
+		return nil, fmt.Errorf("%w: lookup of %s: %w", ErrInvalidResponse, term, err)
+	}
+	return parsedBody, nil
+}
+
+// GetSonarrState looks the series up by TMDB id, falling back to the IMDb id
+// (when known) when it finds nothing or Sonarr rejects the lookup, as the
+// versions that cannot search TMDB ids do. The error of the TMDB lookup is
+// kept when both fail.
+func (sc *Client) GetSonarrState(ctx context.Context, tmdbId string, imdbId string) (SonarrStatus, error) {
+	parsedBody, err := sc.lookup(ctx, "tmdb:"+tmdbId)
+	if imdbId != "" && (err == nil && len(parsedBody) == 0 || isUnsupportedLookup(err)) {
+		imdbBody, imdbErr := sc.lookup(ctx, "imdb:"+imdbId)
+		switch {
+		case imdbErr == nil:
+			parsedBody, err = imdbBody, nil
+		case err == nil:
+			err = imdbErr
+		}
+	}
+
+	if err != nil {
+		return SonarrStatus{}, fmt.Errorf("failed to get series tmdb:%s from Sonarr: %w", tmdbId, err)
+	}
+
+	if len(parsedBody) == 0 {
+		return SonarrStatus{}, fmt.Errorf("%w: tmdb:%s", ErrSeriesNotFound, tmdbId)
+	}
+
+	series := parsedBody[0]
+	return SonarrStatus{
+		HasFile:        series.Statistics.EpisodeFileCount > 0,
+		Monitored:      series.Monitored,
+		Title:          series.Title,
+		TitleSlug:      series.TitleSlug,
+		TmdbId:         tmdbId,
+		TvdbId:         fmt.Sprint(series.TvdbId),
+		ImdbId:         series.ImdbId,
+		ProductionYear: series.Year,
+		IsAnime:        slices.Contains(series.Genres, "Anime"),
+		IsAnimation:    slices.Contains(series.Genres, "Anime") || slices.Contains(series.Genres, "Animation"),
+		InLibrary:      series.Id != 0,
+	}, nil
+}
+
+type SonarrAddBodyAddOptions struct {
+	Monitor                  string `json:"monitor"`
+	SearchForMissingEpisodes bool   `json:"searchForMissingEpisodes"`
+}
+
+type SonarrAddBody struct {
+	TvdbId           int                     `json:"tvdbId"`
+	Title            string                  `json:"title,omitempty"`
+	TitleSlug        string                  `json:"titleSlug,omitempty"`
+	Year             int                     `json:"year,omitempty"`
+	QualityProfileId int                     `json:"qualityProfileId,omitempty"`
+	Monitored        bool                    `json:"monitored"`
+	SeasonFolder     bool                    `json:"seasonFolder"`
+	SeriesType       string                  `json:"seriesType,omitempty"`
+	RootFolderPath   string                  `json:"rootFolderPath,omitempty"`
+	AddOptions       SonarrAddBodyAddOptions `json:"addOptions"`
+}
+
+func (sc *Client) AddToSonarrDownload(ctx context.Context, series SonarrStatus) error {
+	rootFolderPath := sc.RootPaths["series"]
+	if series.IsAnimation {
+		rootFolderPath = sc.RootPaths["anime_series"]
+	}
+	seriesType := "standard"
+	if series.IsAnime {
+		seriesType = "anime"
+	}
+
+	tvdbId, err := strconv.Atoi(series.TvdbId)
+	if err != nil || tvdbId <= 0 {
+		return fmt.Errorf("%w: %s (tmdb:%s)", ErrMissingTvdbId, series.Title, series.TmdbId)
+	}
+
+	reqBody := SonarrAddBody{
+		TvdbId:           tvdbId,
+		Title:            series.Title,
+		TitleSlug:        series.TitleSlug,
+		Year:             series.ProductionYear,
+		QualityProfileId: sc.QualityProfileId,
+		Monitored:        true,
+		SeasonFolder:     true,
+		SeriesType:       seriesType,
+		RootFolderPath:   rootFolderPath,
+		AddOptions: SonarrAddBodyAddOptions{
+			Monitor:                  sc.Monitor,
+			SearchForMissingEpisodes: true,
+		},
+	}
+
+	plan.FromContext(ctx).AddSonarr(plan.SonarrAdd{
+		TmdbId:         series.TmdbId,
+		TvdbId:         series.TvdbId,
+		Title:          series.Title,
+		Year:           series.ProductionYear,
+		RootFolderPath: rootFolderPath,
+		Monitor:        sc.Monitor,
+	})
+
+	_, err = sc.Fetcher.FetchDataContext(ctx, f.FetcherParams{
+		Method: "POST",
+		Url:    sc.Url + "series",
+		Body:   reqBody,
+		Headers: f.Header{
+			"X-Api-Key":    sc.ApiKey,
+			"Content-Type": "application/json",
+		},
+		Params:       f.Param{},
+		WantErrCodes: []int{201},
+	})
+	if errors.Is(err, f.ErrUnexpectedStatus) {
+		return fmt.Errorf("%w: %s (tmdb:%s): %w", ErrSonarrRejected, series.Title, series.TmdbId, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add %s (tmdb:%s) to Sonarr: %w", series.Title, series.TmdbId, err)
+	}
+
+	return nil
+}
+
+// SeriesRef identifies a series to send to Sonarr, the IMDb id is optional.
+type SeriesRef struct {
+	TmdbId string
+	ImdbId string
+}
+
+// SendSeriesToSonarr adds the series missing from Sonarr, like
+// radarr.Client.SendTmdbIDsToRadarr does for movies.
+func (sc *Client) SendSeriesToSonarr(ctx context.Context, series []SeriesRef) ([]SonarrStatus, error) {
+	var states []SonarrStatus
+	var errs []error
+
+	for _, ref := range series {
+		if ref.TmdbId != "" {
+			state, err := sc.GetSonarrState(ctx, ref.TmdbId, ref.ImdbId)
+			if errors.Is(err, ErrSeriesNotFound) {
+				log.Println(err)
+				continue
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !state.InLibrary {
+				err := sc.AddToSonarrDownload(ctx, state)
+				// Sonarr cannot add the series until it gets a TVDB id.
+				if errors.Is(err, ErrMissingTvdbId) {
+					log.Println(err)
+					continue
+				}
+				if err != nil && !errors.Is(err, f.ErrDryRun) {
+					errs = append(errs, err)
+				}
+				state.Added = err == nil
+			}
+			states = append(states, state)
+		}
+	}
+
+	return states, errors.Join(errs...)
+}
@@ -0,0 +1,172 @@
+package sonarr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+)
+
+type MockClient struct {
+	mock.Mock
+}
+
+// Lookups only differ by their term, it is part of the mocked key.
+func (m *MockClient) FetchData(fp f.FetcherParams) ([]byte, error) {
+	key := fp.Url
+	if term, ok := fp.Params["term"]; ok {
+		key += "?term=" + term
+	}
+	args := m.Called(key)
+	return args.Get(0).([]byte), args.Error(1)
+}
+
+func (m *MockClient) FetchDataContext(ctx context.Context, fp f.FetcherParams) ([]byte, error) {
+	return m.FetchData(fp)
+}
+
+func TestGetSonarrState(t *testing.T) {
+	chernobyl, _ := json.Marshal([]SonarrSeriesLookupResp{{
+		Title:     "Chernobyl",
+		TitleSlug: "chernobyl",
+		TvdbId:    360893,
+		TmdbId:    87108,
+		ImdbId:    "tt7366338",
+		Year:      2019,
+		Genres:    []string{"Drama", "History", "Mini-Series"},
+	}})
+	errLookup := errors.New("lookup failed")
+	mononoke, _ := json.Marshal([]SonarrSeriesLookupResp{{
+		Id:         12,
+		Title:      "Mononoke",
+		TitleSlug:  "mononoke",
+		TvdbId:     80279,
+		Year:       2007,
+		Genres:     []string{"Animation", "Anime", "Horror"},
+		Monitored:  true,
+		Statistics: SeriesStatistics{EpisodeFileCount: 12},
+	}})
+
+	tests := []struct {
+		name    string
+		tmdbId  string
+		imdbId  string
+		want    SonarrStatus
+		wantErr error
+	}{
+		{
+			name:   "Test lookup by TMDB id",
+			tmdbId: "87108",
+			want: SonarrStatus{
+				Title:          "Chernobyl",
+				TitleSlug:      "chernobyl",
+				TmdbId:         "87108",
+				TvdbId:         "360893",
+				ImdbId:         "tt7366338",
+				ProductionYear: 2019,
+			},
+		},
+		{
+			name:   "Test fallback to IMDb id",
+			tmdbId: "45845",
+			imdbId: "tt0975949",
+			want: SonarrStatus{
+				HasFile:        true,
+				Monitored:      true,
+				Title:          "Mononoke",
+				TitleSlug:      "mononoke",
+				TmdbId:         "45845",
+				TvdbId:         "80279",
+				ProductionYear: 2007,
+				IsAnime:        true,
+				IsAnimation:    true,
+				InLibrary:      true,
+			},
+		},
+		{
+			name:   "Test fallback to IMDb id of a rejected TMDB lookup",
+			tmdbId: "2",
+			imdbId: "tt0975949",
+			want: SonarrStatus{
+				HasFile:        true,
+				Monitored:      true,
+				Title:          "Mononoke",
+				TitleSlug:      "mononoke",
+				TmdbId:         "2",
+				TvdbId:         "80279",
+				ProductionYear: 2007,
+				IsAnime:        true,
+				IsAnimation:    true,
+				InLibrary:      true,
+			},
+		},
+		{
+			name:    "Test failed TMDB lookup",
+			tmdbId:  "3",
+			imdbId:  "tt3",
+			wantErr: errLookup,
+		},
+		{
+			name:    "Test both lookups rejected",
+			tmdbId:  "4",
+			imdbId:  "tt4",
+			wantErr: f.ErrUnexpectedStatus,
+		},
+		{
+			name:    "Test unknown series",
+			tmdbId:  "1",
+			wantErr: errLookup,
+		},
+	}
+
+	mockClient := new(MockClient)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:87108").Return(chernobyl, nil)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:45845").Return([]byte("[]"), nil)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=imdb:tt0975949").Return(mononoke, nil)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:1").Return([]byte{}, errLookup)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:2").Return([]byte{}, &f.StatusError{StatusCode: 400})
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:3").Return([]byte{}, errLookup)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:4").Return([]byte{}, &f.StatusError{StatusCode: 404})
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=imdb:tt4").Return([]byte{}, errLookup)
+
+	client := &Client{
+		Fetcher: mockClient,
+		Url:     "http://sonarr.test/api/v3/",
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetSonarrState(context.Background(), tt.tmdbId, tt.imdbId)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("GetSonarrState() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetSonarrState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendSeriesToSonarrMissingTvdbId(t *testing.T) {
+	noTvdbId, _ := json.Marshal([]SonarrSeriesLookupResp{{Title: "New series", TitleSlug: "new-series", TmdbId: 1}})
+
+	mockClient := new(MockClient)
+	mockClient.On("FetchData", "http://sonarr.test/api/v3/series/lookup?term=tmdb:1").Return(noTvdbId, nil)
+	client := &Client{Fetcher: mockClient, Url: "http://sonarr.test/api/v3/"}
+
+	states, err := client.SendSeriesToSonarr(context.Background(), []SeriesRef{{TmdbId: "1"}})
+	if err != nil || len(states) != 0 {
+		t.Errorf("SendSeriesToSonarr() = %v, %v, want the series skipped", states, err)
+	}
+	mockClient.AssertNotCalled(t, "FetchData", "http://sonarr.test/api/v3/series")
+
+	if err := client.AddToSonarrDownload(context.Background(), SonarrStatus{Title: "New series", TmdbId: "1", TvdbId: "0"}); !errors.Is(err, ErrMissingTvdbId) {
+		t.Errorf("AddToSonarrDownload() error = %v, want %v", err, ErrMissingTvdbId)
+	}
+}
@@ -0,0 +1,435 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+)
+
+// Number of runs kept in the run history.
+const maxRuns = 100
+
+// Cursor is where the sync of a user stopped.
+type Cursor struct {
+	LatestWatchlistMovie string
+	LastFullSync         time.Time
+	// TMDB ids of collection movies that left the watchlist, with the date
+	// they were first found missing.
+	MissingSince map[string]time.Time `json:",omitempty"`
+}
+
+// IsFullSyncDue reports whether the whole watchlist of the user should be
+// reconciled instead of only its newest entries. A zero interval disables
+// full syncs.
+func (c Cursor) IsFullSyncDue(interval time.Duration) bool {
+	return interval > 0 && time.Since(c.LastFullSync) >= interval
+}
+
+// CollectionSnapshot is the content of a Jellyfin collection the last time it
+// was synced.
+type CollectionSnapshot struct {
+	TmdbKeys []string
+	TakenAt  time.Time
+}
+
+// UserState is everything the runs remember about a Letterboxd user.
+type UserState struct {
+	Cursor
+	// TMDB keys (see letterboxd.Film.TmdbKey) sent to Radarr or Sonarr by the
+	// app, with the date they were sent.
+	Sent map[string]time.Time `json:",omitempty"`
+	// Keyed by Jellyfin collection id.
+	Collections map[string]CollectionSnapshot `json:",omitempty"`
+	// Watchlist collection created for the user because the configured one
+	// was empty or deleted.
+	CreatedCollectionId string `json:",omitempty"`
+	// Private watchlist playlist of the user, see
+	// config.Configuration.WatchlistOutput.
+	PlaylistId string `json:",omitempty"`
+	// TMDB keys of the watchlist movies the Jellyfin webhook reported as
+	// watched, with the date they were watched.
+	Watched map[string]time.Time `json:",omitempty"`
+}
+
+// Run is an entry of the run history.
+type Run struct {
+	Command   string
+	StartedAt time.Time
+	Duration  time.Duration
+	Users     int
+	Failed    int
+}
+
+type data struct {
+	Users map[string]UserState
+	Runs  []Run `json:",omitempty"`
+	// Users added by the users add command, on top of the ones of the
+	// configuration file.
+	AddedUsers []config.UserData `json:",omitempty"`
+}
+
+// Store holds the runtime state of the app, kept apart from the configuration
+// edited by hand. It is saved as a JSON file, replaced atomically, and the
+// previous versions are kept as rolling backups. It is safe for concurrent
+// use.
+type Store struct {
+	path    string
+	backups int
+	isNew   bool
+	mu      sync.Mutex
+	data    data
+}
+
+// Open reads the state file at path, falling back to the newest readable
+// backup when it is corrupted. A missing file gives an empty store. Save keeps
+// the given number of backups.
+func Open(path string, backups int) (*Store, error) {
+	store := &Store{
+		path:    path,
+		backups: backups,
+		data:    data{Users: make(map[string]UserState)},
+	}
+
+	content, err := load(path, backups)
+	if errors.Is(err, fs.ErrNotExist) {
+		store.isNew = true
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if content.Users == nil {
+		content.Users = make(map[string]UserState)
+	}
+	store.data = content
+	return store, nil
+}
+
+// IsNew reports whether the state file did not exist when the store was
+// opened.
+func (s *Store) IsNew() bool {
+	return s.isNew
+}
+
+// ImportLegacy seeds the cursors of the users missing from the store with the
+// state older versions kept in config.json.
+func (s *Store) ImportLegacy(configPath string) error {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var legacy struct {
+		Users []struct {
+			Username string
+			Cursor
+		}
+	}
+	if err := json.Unmarshal(content, &legacy); err != nil {
+		return fmt.Errorf("failed to decode legacy state of %s: %w", configPath, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range legacy.Users {
+		if _, ok := s.data.Users[user.Username]; !ok {
+			s.data.Users[user.Username] = UserState{Cursor: user.Cursor}
+		}
+	}
+	return nil
+}
+
+// User returns a copy of the state of the user.
+func (s *Store) User(username string) UserState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	user.MissingSince = maps.Clone(user.MissingSince)
+	user.Sent = maps.Clone(user.Sent)
+	user.Collections = maps.Clone(user.Collections)
+	user.Watched = maps.Clone(user.Watched)
+	return user
+}
+
+// Cursor returns a copy of the cursor of the user.
+func (s *Store) Cursor(username string) Cursor {
+	return s.User(username).Cursor
+}
+
+func (s *Store) SetCursor(username string, cursor Cursor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	user.Cursor = cursor
+	user.MissingSince = maps.Clone(cursor.MissingSince)
+	s.data.Users[username] = user
+}
+
+// MarkSent records that the given TMDB keys were sent to Radarr or Sonarr.
+func (s *Store) MarkSent(username string, tmdbKeys ...string) {
+	if len(tmdbKeys) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	if user.Sent == nil {
+		user.Sent = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for _, tmdbKey := range tmdbKeys {
+		if _, ok := user.Sent[tmdbKey]; !ok {
+			user.Sent[tmdbKey] = now
+		}
+	}
+	s.data.Users[username] = user
+}
+
+// MarkWatched records that the user watched the movie at the given time.
+func (s *Store) MarkWatched(username string, tmdbKey string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	if user.Watched == nil {
+		user.Watched = make(map[string]time.Time)
+	}
+	user.Watched[tmdbKey] = at
+	s.data.Users[username] = user
+}
+
+// SetCollection replaces the snapshot of the collection of the user.
+func (s *Store) SetCollection(username string, collectionId string, tmdbKeys []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	if user.Collections == nil {
+		user.Collections = make(map[string]CollectionSnapshot)
+	}
+	tmdbKeys = slices.Clone(tmdbKeys)
+	slices.Sort(tmdbKeys)
+	user.Collections[collectionId] = CollectionSnapshot{TmdbKeys: tmdbKeys, TakenAt: time.Now()}
+	s.data.Users[username] = user
+}
+
+// SetCreatedCollection records the watchlist collection created for the user,
+// an empty id forgets it.
+func (s *Store) SetCreatedCollection(username string, collectionId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	user.CreatedCollectionId = collectionId
+	s.data.Users[username] = user
+}
+
+// SetPlaylist records the watchlist playlist created for the user.
+func (s *Store) SetPlaylist(username string, playlistId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.data.Users[username]
+	user.PlaylistId = playlistId
+	s.data.Users[username] = user
+}
+
+// WatchlistCollection returns the id of the watchlist collection of the user,
+// the one created for the user replacing the configured one.
+func (s *Store) WatchlistCollection(user config.UserData) string {
+	if created := s.User(user.Username).CreatedCollectionId; created != "" {
+		return created
+	}
+	return user.CollectionId
+}
+
+// AddedUsers returns the users added by the users add command.
+func (s *Store) AddedUsers() []config.UserData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.data.AddedUsers)
+}
+
+// AddUser adds the user, replacing the added user with the same username.
+func (s *Store) AddUser(user config.UserData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.AddedUsers = slices.DeleteFunc(s.data.AddedUsers, func(added config.UserData) bool {
+		return strings.EqualFold(added.Username, user.Username)
+	})
+	s.data.AddedUsers = append(s.data.AddedUsers, user)
+}
+
+// RemoveUser removes the added user and its state, it returns false when the
+// user was not added by AddUser.
+func (s *Store) RemoveUser(username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.data.AddedUsers, func(added config.UserData) bool {
+		return strings.EqualFold(added.Username, username)
+	})
+	if index < 0 {
+		return false
+	}
+	delete(s.data.Users, s.data.AddedUsers[index].Username)
+	s.data.AddedUsers = slices.Delete(s.data.AddedUsers, index, index+1)
+	return true
+}
+
+// AddRun appends the run to the history, only the last maxRuns are kept.
+func (s *Store) AddRun(run Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Runs = append(s.data.Runs, run)
+	if len(s.data.Runs) > maxRuns {
+		s.data.Runs = slices.Clone(s.data.Runs[len(s.data.Runs)-maxRuns:])
+	}
+}
+
+// Runs returns the run history, oldest first.
+func (s *Store) Runs() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.data.Runs)
+}
+
+// Save writes the state file, keeping the previous one as the newest backup.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := json.MarshalIndent(s.data, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := rotateBackups(s.path, s.backups); err != nil {
+		return fmt.Errorf("failed to back up state file: %w", err)
+	}
+	if err := writeFileAtomic(s.path, content, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	s.isNew = false
+	return nil
+}
+
+func backupPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+// load reads the state file, falling back to the newest readable backup when
+// it is corrupted. fs.ErrNotExist is returned when there is no state file at
+// all.
+func load(path string, backups int) (data, error) {
+	content, err := read(path)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return content, err
+	}
+
+	for index := 1; index <= backups; index++ {
+		backup, backupErr := read(backupPath(path, index))
+		if backupErr == nil {
+			log.Printf("State file %s is unreadable (%v), using backup %d", path, err, index)
+			return backup, nil
+		}
+	}
+	return data{}, fmt.Errorf("failed to read state file: %w", err)
+}
+
+func read(path string) (data, error) {
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return data{}, err
+	}
+
+	var content data
+	if err := json.Unmarshal(fileContent, &content); err != nil {
+		return data{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return content, nil
+}
+
+// rotateBackups shifts path.1 ... path.N-1 by one and copies the current file
+// to path.1. The current file stays in place until it is replaced.
+func rotateBackups(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for index := backups - 1; index >= 1; index-- {
+		err := os.Rename(backupPath(path, index), backupPath(path, index+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return writeFileAtomic(backupPath(path, 1), content, 0600)
+}
+
+// writeFileAtomic writes content to a temporary file synced to disk before
+// renaming it over path, so that path holds either the old or the new content
+// even after a crash.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself.
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer dirFile.Close()
+	return dirFile.Sync()
+}
@@ -0,0 +1,262 @@
+package state
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+)
+
+func testCursor(cursor string) Cursor {
+	return Cursor{
+		LatestWatchlistMovie: cursor,
+		LastFullSync:         time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestIsFullSyncDue(t *testing.T) {
+	tests := []struct {
+		name         string
+		lastFullSync time.Time
+		interval     time.Duration
+		want         bool
+	}{
+		{name: "Test never synced", lastFullSync: time.Time{}, interval: time.Hour, want: true},
+		{name: "Test interval elapsed", lastFullSync: time.Now().Add(-2 * time.Hour), interval: time.Hour, want: true},
+		{name: "Test interval not elapsed", lastFullSync: time.Now().Add(-30 * time.Minute), interval: time.Hour, want: false},
+		{name: "Test disabled", lastFullSync: time.Time{}, interval: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := Cursor{LastFullSync: tt.lastFullSync}
+			if got := cursor.IsFullSyncDue(tt.interval); got != tt.want {
+				t.Errorf("IsFullSyncDue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	for _, cursor := range []string{"first", "second", "third", "fourth"} {
+		store, err := Open(path, 2)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		store.SetCursor("user", testCursor(cursor))
+		store.MarkSent("user", "tv:1399")
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantCursor string
+	}{
+		{name: "Test current state", path: path, wantCursor: "fourth"},
+		{name: "Test newest backup", path: backupPath(path, 1), wantCursor: "third"},
+		{name: "Test oldest backup", path: backupPath(path, 2), wantCursor: "second"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := read(tt.path)
+			if err != nil {
+				t.Fatalf("read() error = %v", err)
+			}
+			if !reflect.DeepEqual(got.Users["user"].Cursor, testCursor(tt.wantCursor)) {
+				t.Errorf("read() = %v, want cursor %s", got.Users["user"].Cursor, tt.wantCursor)
+			}
+			if _, ok := got.Users["user"].Sent["tv:1399"]; !ok {
+				t.Errorf("read() = %v, want tv:1399 sent", got.Users["user"].Sent)
+			}
+		})
+	}
+
+	if _, err := os.Stat(backupPath(path, 3)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("backup 3 exists, want only 2 backups")
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    string
+		backups    []string
+		wantNew    bool
+		wantCursor Cursor
+		wantErr    bool
+	}{
+		{
+			name:    "Test missing state",
+			wantNew: true,
+		},
+		{
+			name:       "Test valid state",
+			current:    `{"Users":{"user":{"LatestWatchlistMovie":"current","LastFullSync":"2024-05-01T12:00:00Z"}}}`,
+			wantCursor: testCursor("current"),
+		},
+		{
+			name:       "Test truncated state falls back to a backup",
+			current:    `{"Users":{"user":{"Lat`,
+			backups:    []string{"", `{"Users":{"user":{"LatestWatchlistMovie":"backup","LastFullSync":"2024-05-01T12:00:00Z"}}}`},
+			wantCursor: testCursor("backup"),
+		},
+		{
+			name:    "Test truncated state without backup",
+			current: `{"Users":{"user":{"Lat`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.current != "" {
+				os.WriteFile(path, []byte(tt.current), 0600)
+			}
+			for index, backup := range tt.backups {
+				os.WriteFile(backupPath(path, index+1), []byte(backup), 0600)
+			}
+
+			store, err := Open(path, len(tt.backups))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if store.IsNew() != tt.wantNew {
+				t.Errorf("IsNew() = %v, want %v", store.IsNew(), tt.wantNew)
+			}
+			if got := store.Cursor("user"); !reflect.DeepEqual(got, tt.wantCursor) {
+				t.Errorf("Cursor() = %v, want %v", got, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	os.WriteFile(configPath, []byte(`{"Users":[
+		{"Username":"user","LatestWatchlistMovie":"legacy","CollectionId":"1","LastFullSync":"2024-05-01T12:00:00Z"},
+		{"Username":"known","LatestWatchlistMovie":"legacy"}
+	]}`), 0600)
+
+	store, err := Open(filepath.Join(dir, "state.json"), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	store.SetCursor("known", testCursor("current"))
+
+	if err := store.ImportLegacy(configPath); err != nil {
+		t.Fatalf("ImportLegacy() error = %v", err)
+	}
+	if got := store.Cursor("user"); !reflect.DeepEqual(got, testCursor("legacy")) {
+		t.Errorf("Cursor(user) = %v, want %v", got, testCursor("legacy"))
+	}
+	if got := store.Cursor("known"); !reflect.DeepEqual(got, testCursor("current")) {
+		t.Errorf("Cursor(known) = %v, want %v", got, testCursor("current"))
+	}
+}
+
+func TestAddRun(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	for index := range maxRuns + 5 {
+		store.AddRun(Run{Command: "sync", Users: index})
+	}
+
+	runs := store.Runs()
+	if len(runs) != maxRuns {
+		t.Fatalf("len(Runs()) = %d, want %d", len(runs), maxRuns)
+	}
+	if runs[0].Users != 5 || runs[maxRuns-1].Users != maxRuns+4 {
+		t.Errorf("Runs() kept runs %d to %d, want 5 to %d", runs[0].Users, runs[maxRuns-1].Users, maxRuns+4)
+	}
+}
+
+func TestAddedUsers(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	store.AddUser(config.UserData{Username: "user", CollectionId: "1"})
+	store.AddUser(config.UserData{Username: "other", CollectionId: "2"})
+	store.AddUser(config.UserData{Username: "User", CollectionId: "3"})
+	store.SetCursor("other", testCursor("other"))
+
+	want := []config.UserData{{Username: "other", CollectionId: "2"}, {Username: "User", CollectionId: "3"}}
+	if got := store.AddedUsers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AddedUsers() = %v, want %v", got, want)
+	}
+
+	if !store.RemoveUser("OTHER") {
+		t.Errorf("RemoveUser(OTHER) = false, want true")
+	}
+	if store.RemoveUser("unknown") {
+		t.Errorf("RemoveUser(unknown) = true, want false")
+	}
+	if got := store.Cursor("other"); !reflect.DeepEqual(got, Cursor{}) {
+		t.Errorf("Cursor(other) = %v, want the state removed", got)
+	}
+}
+
+func TestWatchlistCollection(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	user := config.UserData{Username: "user", CollectionId: "configured"}
+
+	tests := []struct {
+		name    string
+		created string
+		want    string
+	}{
+		{name: "Test configured collection", created: "", want: "configured"},
+		{name: "Test created collection", created: "created", want: "created"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.SetCreatedCollection(user.Username, tt.created)
+			if got := store.WatchlistCollection(user); got != tt.want {
+				t.Errorf("WatchlistCollection() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkWatched(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "state.json"), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	first := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+
+	store.MarkWatched("user", "194", first)
+	store.MarkWatched("user", "194", second)
+	watched := store.User("user").Watched
+	watched["603"] = first
+
+	want := map[string]time.Time{"194": second}
+	if got := store.User("user").Watched; !reflect.DeepEqual(got, want) {
+		t.Errorf("User().Watched = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	lt "diikstra.fr/letterboxd-jellyfin-go/letterboxd"
+	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+	sn "diikstra.fr/letterboxd-jellyfin-go/sonarr"
+)
+
+var ErrUsersFailed = errors.New("sync failed for some users")
+
+// userResult is the outcome of the sync of a user, Err is nil on success.
+type userResult struct {
+	Username string
+	Err      error
+	Duration time.Duration
+	Skipped  bool
+}
+
+// errorKind names the known cause of a sync error for the run summary.
+func errorKind(err error) string {
+	kinds := []struct {
+		target error
+		kind   string
+	}{
+		{context.DeadlineExceeded, "run budget exceeded"},
+		{context.Canceled, "interrupted"},
+		{jf.ErrUserNotFound, "jellyfin user not found"},
+		{lt.ErrUserNotFound, "letterboxd user not found"},
+		{lt.ErrScrapeBlocked, "scraping blocked"},
+		{rd.ErrRadarrRejected, "radarr rejected"},
+		{sn.ErrSonarrRejected, "sonarr rejected"},
+		{rd.ErrInvalidResponse, "invalid radarr response"},
+		{sn.ErrInvalidResponse, "invalid sonarr response"},
+		{jf.ErrInvalidResponse, "invalid jellyfin response"},
+	}
+	for _, k := range kinds {
+		if errors.Is(err, k.target) {
+			return k.kind
+		}
+	}
+	return "error"
+}
+
+// writeSummary prints one line per user and returns the number of users
+// whose sync failed or was skipped.
+func writeSummary(w io.Writer, results []userResult) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	failed := 0
+	fmt.Fprintf(tw, "\nRUN SUMMARY (%d users)\n", len(results))
+	fmt.Fprintln(tw, "USER\tSTATUS\tDURATION\tERROR")
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			failed++
+			fmt.Fprintf(tw, "%s\tskipped\t-\t%v\n", result.Username, result.Err)
+		case result.Err != nil:
+			failed++
+			fmt.Fprintf(tw, "%s\tfailed (%s)\t%s\t%v\n", result.Username, errorKind(result.Err), result.Duration.Round(time.Second), result.Err)
+		default:
+			fmt.Fprintf(tw, "%s\tok\t%s\t\n", result.Username, result.Duration.Round(time.Second))
+		}
+	}
+
+	return failed, tw.Flush()
+}
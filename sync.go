@@ -0,0 +1,408 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	lt "diikstra.fr/letterboxd-jellyfin-go/letterboxd"
+	rd "diikstra.fr/letterboxd-jellyfin-go/radarr"
+	sn "diikstra.fr/letterboxd-jellyfin-go/sonarr"
+	"diikstra.fr/letterboxd-jellyfin-go/state"
+)
+
+// syncer holds the clients shared by the commands syncing Letterboxd data to
+// Radarr, Sonarr and Jellyfin.
+type syncer struct {
+	conf     *config.Configuration
+	state    *state.Store
+	scrapper lt.LetterboxdScrapper
+	jellyfin *jf.Client
+	radarr   *rd.Client
+	// nil when Sonarr is disabled, the series are then skipped.
+	sonarr *sn.Client
+
+	// The Jellyfin library, reloaded by refreshLibrary.
+	libraryMu       sync.RWMutex
+	allMovies       *[]jf.MoviesItem
+	libraryLoadedAt time.Time
+}
+
+// newSyncer checks the Radarr and Sonarr settings and loads the Jellyfin
+// library.
+func newSyncer(ctx context.Context, fetcher f.Fetcher, slugCache *lt.SlugCache, store *state.Store, conf *config.Configuration) (*syncer, error) {
+	s := &syncer{
+		conf:  conf,
+		state: store,
+		scrapper: lt.LetterboxdScrapper{
+			Client:  fetcher,
+			Cache:   slugCache,
+			Workers: conf.ScrapeWorkers,
+		},
+		jellyfin: jf.NewClient(fetcher, conf),
+		radarr:   rd.NewClient(fetcher, conf),
+	}
+	if err := s.radarr.ResolveSettings(ctx); err != nil {
+		return nil, err
+	}
+
+	// Sonarr is optional, without it the series of the watchlists are skipped.
+	if conf.SonarrUrl != "" {
+		s.sonarr = sn.NewClient(fetcher, conf)
+		if err := s.sonarr.ResolveSettings(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.refreshLibrary(ctx, 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// refreshLibrary reloads the Jellyfin library when it was loaded more than
+// maxAge ago, the items downloaded since then can then be added to the
+// collections.
+func (s *syncer) refreshLibrary(ctx context.Context, maxAge time.Duration) error {
+	s.libraryMu.Lock()
+	defer s.libraryMu.Unlock()
+
+	if s.allMovies != nil && time.Since(s.libraryLoadedAt) < maxAge {
+		return nil
+	}
+	allMovies, err := s.jellyfin.GetAllMovies(ctx)
+	if err != nil {
+		return err
+	}
+	s.allMovies = allMovies
+	s.libraryLoadedAt = time.Now()
+	return nil
+}
+
+func (s *syncer) library() *[]jf.MoviesItem {
+	s.libraryMu.RLock()
+	defer s.libraryMu.RUnlock()
+
+	return s.allMovies
+}
+
+// syncUser sends the newest watchlist entries of the user to Radarr, or to
+// Sonarr for series, and adds them to their Jellyfin collection. When a full
+// sync is due, the whole watchlist is diffed against the collection instead,
+// catching movies that were added out of order or missed by the
+// LatestWatchlistMovie marker. The lists the user subscribed to are synced
+// afterwards. A failing step does not stop the following ones, their errors
+// are joined. The cursor of the user is updated in the state store even when
+// the sync fails. forceFull runs a full sync even if it is not due.
+func (s *syncer) syncUser(ctx context.Context, user *config.UserData, forceFull bool) error {
+	userId, err := s.jellyfin.GetUserId(ctx, user.JellyfinUserName)
+	if err != nil {
+		return err
+	}
+
+	targets, err := s.watchlistTargets(ctx, user, userId)
+	if err != nil {
+		return err
+	}
+
+	cursor := s.state.Cursor(user.Username)
+	defer func() {
+		s.state.SetCursor(user.Username, cursor)
+	}()
+	fullSync := forceFull || cursor.IsFullSyncDue(time.Duration(s.conf.FullSyncIntervalHours)*time.Hour)
+
+	var errs []error
+	var films []lt.Film
+	if fullSync {
+		log.Printf("Running full sync of %s, last one was on %s", user.Username, cursor.LastFullSync.Format(time.RFC3339))
+		// An incomplete watchlist still gets its entries added, but the
+		// movies it misses must not be taken for unlisted ones.
+		watchlist, err := s.scrapper.GetFullUserWatchlist(ctx, user.Username)
+		complete := err == nil
+		if errors.Is(err, lt.ErrIncompleteWatchlist) {
+			errs = append(errs, err)
+		} else if err != nil {
+			return err
+		}
+
+		var targetTmdbIds []map[string]bool
+		for _, target := range targets {
+			tmdbIds, err := s.jellyfin.GetCollectionTmdbIds(ctx, userId, target)
+			if err != nil {
+				return err
+			}
+			targetTmdbIds = append(targetTmdbIds, tmdbIds)
+		}
+		for _, film := range watchlist {
+			missing := slices.ContainsFunc(targetTmdbIds, func(tmdbIds map[string]bool) bool {
+				return !tmdbIds[film.TmdbKey()]
+			})
+			if missing {
+				films = append(films, film)
+			}
+		}
+		log.Printf("%d of %d watchlist entries are missing from the collection or playlist", len(films), len(watchlist))
+
+		if len(watchlist) > 0 {
+			cursor.LatestWatchlistMovie = watchlist[0].TmdbKey()
+		}
+
+		if s.conf.RemoveUnlistedMovies && !complete {
+			log.Printf("Not removing unlisted movies of %s, the watchlist is incomplete", user.Username)
+		}
+		if s.conf.RemoveUnlistedMovies && complete {
+			watchlistTmdbIds := make(map[string]bool)
+			for _, film := range watchlist {
+				watchlistTmdbIds[film.TmdbKey()] = true
+			}
+			// Each target forgets the movies it does not hold, the ones still
+			// missing from another target are kept with their first date.
+			missingSince := make(map[string]time.Time)
+			gracePeriod := time.Duration(s.conf.UnlistedGracePeriodHours) * time.Hour
+			for _, target := range targets {
+				targetMissingSince := maps.Clone(cursor.MissingSince)
+				if targetMissingSince == nil {
+					targetMissingSince = make(map[string]time.Time)
+				}
+				if _, err := s.jellyfin.RemoveUnlistedMoviesFromCollection(ctx, userId, target, watchlistTmdbIds, targetMissingSince, gracePeriod); err != nil {
+					errs = append(errs, err)
+				}
+				for tmdbId, since := range targetMissingSince {
+					if first, ok := missingSince[tmdbId]; !ok || since.Before(first) {
+						missingSince[tmdbId] = since
+					}
+				}
+			}
+			cursor.MissingSince = missingSince
+		}
+	} else {
+		// The entries found before one failing to resolve are still added.
+		films, err = s.scrapper.GetNewestUserWatchlist(ctx, user.Username, &cursor.LatestWatchlistMovie)
+		if errors.Is(err, lt.ErrIncompleteWatchlist) {
+			errs = append(errs, err)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if err := s.addFilms(ctx, films, user.Username, userId, targets); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Seen movies are removed last so that a movie the user already watched is
+	// not kept in the collection until the next run.
+	for _, target := range targets {
+		if _, err := s.jellyfin.RemoveSeenMoviesFromUserCollection(ctx, userId, target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// A failed full sync is retried on the next run.
+	if fullSync && ctx.Err() == nil && len(errs) == 0 {
+		cursor.LastFullSync = time.Now()
+	}
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := s.snapshotCollection(ctx, user.Username, userId, target); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, list := range user.Lists {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := s.syncList(ctx, user.Username, userId, list); err != nil {
+			errs = append(errs, fmt.Errorf("list %s/%s: %w", list.Owner, list.Slug, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// watchlistTargets returns the collection and the playlist the watchlist of
+// the user is synced to, depending on config.Configuration.WatchlistOutput.
+// Their ids are empty when a dry run only planned their creation.
+func (s *syncer) watchlistTargets(ctx context.Context, user *config.UserData, userId string) ([]jf.Target, error) {
+	var targets []jf.Target
+	if s.conf.SyncsCollections() {
+		collectionId, err := s.watchlistCollection(ctx, user, userId)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, jf.Collection(collectionId))
+	}
+	if s.conf.SyncsPlaylists() {
+		playlistId, created, err := s.jellyfin.EnsurePlaylist(ctx, userId, s.state.User(user.Username).PlaylistId, s.watchlistName(user))
+		if err != nil {
+			return nil, err
+		}
+		if created && playlistId != "" {
+			s.state.SetPlaylist(user.Username, playlistId)
+		}
+		targets = append(targets, jf.Playlist(playlistId))
+	}
+	return targets, nil
+}
+
+// storedTargets returns the collection and the playlist of the user known by
+// the configuration and the state, without checking or creating them. Their
+// ids are empty when they are created on the next sync.
+func storedTargets(conf *config.Configuration, store *state.Store, user config.UserData) []jf.Target {
+	var targets []jf.Target
+	if conf.SyncsCollections() {
+		targets = append(targets, jf.Collection(store.WatchlistCollection(user)))
+	}
+	if conf.SyncsPlaylists() {
+		targets = append(targets, jf.Playlist(store.User(user.Username).PlaylistId))
+	}
+	return targets
+}
+
+// userReplacer replaces {user} and {jellyfin_user} by the Letterboxd and
+// Jellyfin user names.
+func userReplacer(user *config.UserData) *strings.Replacer {
+	return strings.NewReplacer("{user}", user.Username, "{jellyfin_user}", user.JellyfinUserName)
+}
+
+// watchlistName returns the name of the collection and the playlist created
+// for the user.
+func (s *syncer) watchlistName(user *config.UserData) string {
+	return userReplacer(user).Replace(cmp.Or(s.conf.CollectionName, config.DefaultCollectionName))
+}
+
+// watchlistCollection returns the id of the watchlist collection of the
+// user. The configured collection is used while it exists, otherwise the one
+// created for the user, which is created when it is missing too.
+func (s *syncer) watchlistCollection(ctx context.Context, user *config.UserData, userId string) (string, error) {
+	created := s.state.User(user.Username).CreatedCollectionId
+	collectionId, isNew, err := s.jellyfin.EnsureCollection(ctx, userId, []string{user.CollectionId, created}, jf.NewCollection{
+		Name:      s.watchlistName(user),
+		Overview:  userReplacer(user).Replace(s.conf.CollectionOverview),
+		PosterUrl: s.conf.CollectionPosterUrl,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case collectionId != "" && collectionId == user.CollectionId && created != "":
+		// The configured collection is back, the created one is not used
+		// anymore.
+		s.state.SetCreatedCollection(user.Username, "")
+	case isNew && collectionId != "":
+		s.state.SetCreatedCollection(user.Username, collectionId)
+	}
+	return collectionId, nil
+}
+
+// syncList adds the entries of the Letterboxd list that are missing from its
+// collection, in list order, tagging the Radarr adds with the list tag.
+func (s *syncer) syncList(ctx context.Context, requestedBy string, userId string, list config.LetterboxdList) error {
+	entries, err := s.scrapper.GetList(ctx, list.Owner, list.Slug)
+	if err != nil {
+		return err
+	}
+	slices.SortStableFunc(entries, func(a, b lt.ListEntry) int {
+		return a.Position - b.Position
+	})
+
+	target := jf.Collection(list.CollectionId)
+	collectionTmdbIds, err := s.jellyfin.GetCollectionTmdbIds(ctx, userId, target)
+	if err != nil {
+		return err
+	}
+
+	var films []lt.Film
+	for _, entry := range entries {
+		if !collectionTmdbIds[entry.TmdbKey()] {
+			films = append(films, entry.Film)
+		}
+	}
+	log.Printf("%d of %d entries of list %s/%s are missing from the collection", len(films), len(entries), list.Owner, list.Slug)
+
+	var tags []string
+	if list.RadarrTag != "" {
+		tags = append(tags, list.RadarrTag)
+	}
+	if err := s.addFilms(ctx, films, requestedBy, userId, []jf.Target{target}, tags...); err != nil {
+		return err
+	}
+	return s.snapshotCollection(ctx, requestedBy, userId, target)
+}
+
+// snapshotCollection records the content of the collection or playlist of the
+// user in the state store. Targets only planned by a dry run are skipped.
+func (s *syncer) snapshotCollection(ctx context.Context, username string, userId string, target jf.Target) error {
+	if target.Id == "" {
+		return nil
+	}
+	collectionTmdbIds, err := s.jellyfin.GetCollectionTmdbIds(ctx, userId, target)
+	if err != nil {
+		return err
+	}
+
+	tmdbKeys := make([]string, 0, len(collectionTmdbIds))
+	for tmdbKey := range collectionTmdbIds {
+		tmdbKeys = append(tmdbKeys, tmdbKey)
+	}
+	s.state.SetCollection(username, target.Id, tmdbKeys)
+	return nil
+}
+
+// addFilms sends the movies to Radarr and the series to Sonarr, then adds
+// them to the collections and playlists. Series are skipped when Sonarr is disabled. The
+// films added to Radarr or Sonarr are recorded as sent in the state store.
+func (s *syncer) addFilms(ctx context.Context, films []lt.Film, requestedBy string, userId string, targets []jf.Target, tags ...string) error {
+	var tmdbIds []string
+	var series []sn.SeriesRef
+	for _, film := range films {
+		if film.IsSeries() {
+			series = append(series, sn.SeriesRef{TmdbId: film.TmdbId, ImdbId: film.ImdbId})
+		} else {
+			tmdbIds = append(tmdbIds, film.TmdbId)
+		}
+	}
+
+	// Movies that could not be sent are still added when Radarr already has
+	// them, hence the errors are only returned at the end.
+	radarrStates, radarrErr := s.radarr.SendTmdbIDsToRadarr(ctx, tmdbIds, requestedBy, tags...)
+	for _, movie := range radarrStates {
+		if movie.Added {
+			s.state.MarkSent(requestedBy, movie.TmdbId)
+		}
+	}
+	errs := []error{radarrErr}
+	for _, target := range targets {
+		errs = append(errs, s.jellyfin.AddMoviesToCollection(ctx, s.library(), radarrStates, userId, target))
+	}
+
+	if s.sonarr != nil {
+		sonarrStates, sonarrErr := s.sonarr.SendSeriesToSonarr(ctx, series)
+		for _, show := range sonarrStates {
+			if show.Added {
+				s.state.MarkSent(requestedBy, "tv:"+show.TmdbId)
+			}
+		}
+		errs = append(errs, sonarrErr)
+		for _, target := range targets {
+			errs = append(errs, s.jellyfin.AddSeriesToCollection(ctx, s.library(), sonarrStates, userId, target))
+		}
+	} else if len(series) > 0 {
+		log.Printf("Skipping %d series requested by %s, Sonarr is not configured", len(series), requestedBy)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	"diikstra.fr/letterboxd-jellyfin-go/state"
+)
+
+// Path of the endpoint the Jellyfin Webhook plugin posts to.
+const webhookPath = "/webhook"
+
+// Maximum size of a notification, the plugin sends a few hundred bytes.
+const maxWebhookBodySize = 1 << 20
+
+// Maximum duration of the handling of a notification.
+const webhookTimeout = time.Minute
+
+// webhookHandler removes the movies the users finish from their watchlist
+// collection and playlist as soon as the Jellyfin Webhook plugin reports it,
+// instead of on their next sync, and records them as watched.
+type webhookHandler struct {
+	syncer *syncer
+	store  *state.Store
+	secret string
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Webhook-Secret")), []byte(h.secret)) != 1 {
+		http.Error(w, "invalid secret", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	event, err := jf.ParseWebhookEvent(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !event.IsFinishedMovie() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), webhookTimeout)
+	defer cancel()
+	if err := h.handle(ctx, event); err != nil {
+		log.Printf("Failed to handle the %s notification of %s for %s: %v", event.NotificationType, event.Name, event.NotificationUsername, err)
+		http.Error(w, "failed to handle the notification", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handle removes the movie from the collections and playlists of the users
+// synced to the Jellyfin user of the event. The movies that were in one of
+// them are recorded as watched and the state is saved.
+func (h *webhookHandler) handle(ctx context.Context, event jf.WebhookEvent) error {
+	var errs []error
+	watched := false
+	for index := range h.syncer.conf.Users {
+		user := &h.syncer.conf.Users[index]
+		if !strings.EqualFold(user.JellyfinUserName, event.NotificationUsername) {
+			continue
+		}
+
+		userId, err := h.syncer.jellyfin.GetUserId(ctx, user.JellyfinUserName)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, target := range storedTargets(h.syncer.conf, h.store, *user) {
+			if target.Id == "" {
+				continue
+			}
+			item, err := h.syncer.jellyfin.RemoveItemFromCollection(ctx, userId, target, event.ItemId, "played")
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if item == nil {
+				continue
+			}
+			if tmdbKey := item.TmdbKey(); tmdbKey != "" {
+				h.store.MarkWatched(user.Username, tmdbKey, time.Now())
+			}
+			watched = true
+		}
+	}
+
+	if watched {
+		if err := h.store.Save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"diikstra.fr/letterboxd-jellyfin-go/config"
+	f "diikstra.fr/letterboxd-jellyfin-go/fetch"
+	jf "diikstra.fr/letterboxd-jellyfin-go/jellyfin"
+	"diikstra.fr/letterboxd-jellyfin-go/state"
+)
+
+// fakeJellyfin answers the Jellyfin users and the items of every collection
+// with views, and records the other requests.
+type fakeJellyfin struct {
+	views    []jf.UserView
+	requests []f.FetcherParams
+}
+
+func (fj *fakeJellyfin) FetchData(fp f.FetcherParams) ([]byte, error) {
+	if fp.Method != "GET" {
+		fj.requests = append(fj.requests, fp)
+		return nil, nil
+	}
+	if strings.HasSuffix(fp.Url, "/Users") {
+		return json.Marshal([]jf.User{{Name: "jellyfin-user", Id: "user"}})
+	}
+	return json.Marshal(jf.ReqUserViewWrapper{Items: fj.views})
+}
+
+func (fj *fakeJellyfin) FetchDataContext(ctx context.Context, fp f.FetcherParams) ([]byte, error) {
+	return fj.FetchData(fp)
+}
+
+func newTestWebhookHandler(t *testing.T, client f.FetcherClient, jellyfinUrl string, statePath string) *webhookHandler {
+	t.Helper()
+	store, err := state.Open(statePath, 0)
+	if err != nil {
+		t.Fatalf("state.Open() returned error: %v", err)
+	}
+	conf := &config.Configuration{
+		JellyfinUrl:    jellyfinUrl,
+		JellyfinApiKey: "test",
+		Users: []config.UserData{
+			{Username: "someone", JellyfinUserName: "jellyfin-user", CollectionId: "collection"},
+		},
+	}
+	return &webhookHandler{
+		syncer: &syncer{conf: conf, state: store, jellyfin: jf.NewClient(client, conf)},
+		store:  store,
+		secret: "secret",
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	views := []jf.UserView{{Name: "Alien", Id: "alien", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "348"}}}
+
+	tests := []struct {
+		name        string
+		secret      string
+		body        string
+		wantStatus  int
+		wantRemoved bool
+	}{
+		{
+			name:       "Test missing secret",
+			body:       `{"NotificationType":"PlaybackStop","ItemId":"alien","ItemType":"Movie","NotificationUsername":"jellyfin-user","PlayedToCompletion":"True"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Test invalid secret",
+			secret:     "guess",
+			body:       `{"NotificationType":"PlaybackStop","ItemId":"alien","ItemType":"Movie","NotificationUsername":"jellyfin-user","PlayedToCompletion":"True"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Test invalid body",
+			secret:     "secret",
+			body:       `{"NotificationType":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Test playback stopped halfway",
+			secret:     "secret",
+			body:       `{"NotificationType":"PlaybackStop","ItemId":"alien","ItemType":"Movie","NotificationUsername":"jellyfin-user","PlayedToCompletion":"False"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Test other notification",
+			secret:     "secret",
+			body:       `{"NotificationType":"ItemAdded","ItemId":"alien","ItemType":"Movie","NotificationUsername":"jellyfin-user"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:        "Test playback stopped at the end",
+			secret:      "secret",
+			body:        `{"NotificationType":"PlaybackStop","ItemId":"alien","ItemType":"Movie","NotificationUsername":"jellyfin-user","PlayedToCompletion":"True"}`,
+			wantStatus:  http.StatusNoContent,
+			wantRemoved: true,
+		},
+		{
+			name:        "Test marked as played",
+			secret:      "secret",
+			body:        `{"NotificationType":"UserDataSaved","ItemId":"alien","ItemType":"Movie","NotificationUsername":"jellyfin-user","Played":"True"}`,
+			wantStatus:  http.StatusNoContent,
+			wantRemoved: true,
+		},
+		{
+			name:       "Test movie not in the watchlist",
+			secret:     "secret",
+			body:       `{"NotificationType":"PlaybackStop","ItemId":"aliens","ItemType":"Movie","NotificationUsername":"jellyfin-user","PlayedToCompletion":"True"}`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Test other user",
+			secret:     "secret",
+			body:       `{"NotificationType":"PlaybackStop","ItemId":"alien","ItemType":"Movie","NotificationUsername":"someone-else","PlayedToCompletion":"True"}`,
+			wantStatus: http.StatusNoContent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeJellyfin{views: views}
+			handler := newTestWebhookHandler(t, client, "http://jellyfin.test", filepath.Join(t.TempDir(), "state.json"))
+
+			req := httptest.NewRequest("POST", webhookPath, strings.NewReader(tt.body))
+			if tt.secret != "" {
+				req.Header.Set("X-Webhook-Secret", tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			wantRequests := 0
+			if tt.wantRemoved {
+				wantRequests = 1
+			}
+			if len(client.requests) != wantRequests {
+				t.Fatalf("sent %d requests, want %d", len(client.requests), wantRequests)
+			}
+			if tt.wantRemoved {
+				got := client.requests[0]
+				if got.Method != "DELETE" || got.Url != "http://jellyfin.test/Collections/collection/Items" || got.Params["ids"] != "alien" {
+					t.Errorf("sent %s %s %v, want the removal of alien from the collection", got.Method, got.Url, got.Params)
+				}
+			}
+			if _, watched := handler.store.User("someone").Watched["348"]; watched != tt.wantRemoved {
+				t.Errorf("movie recorded as watched = %v, want %v", watched, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestWebhookEndpoint(t *testing.T) {
+	var removed []string
+	jellyfin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/Users":
+			json.NewEncoder(w).Encode([]jf.User{{Name: "jellyfin-user", Id: "user"}})
+		case r.Method == "GET" && r.URL.Path == "/Items" && r.URL.Query().Get("ParentId") == "collection":
+			json.NewEncoder(w).Encode(jf.ReqUserViewWrapper{Items: []jf.UserView{
+				{Name: "Alien", Id: "0123456789abcdef0123456789abcdef", Type: "Movie", ProviderIds: map[string]string{"Tmdb": "348"}},
+			}})
+		case r.Method == "DELETE" && r.URL.Path == "/Collections/collection/Items":
+			removed = append(removed, r.URL.Query().Get("ids"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer jellyfin.Close()
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	handler := newTestWebhookHandler(t, f.Fetcher{Retry: &f.NoRetry}, jellyfin.URL, statePath)
+	mux := http.NewServeMux()
+	mux.Handle("POST "+webhookPath, handler)
+	endpoint := httptest.NewServer(mux)
+	defer endpoint.Close()
+
+	req, _ := http.NewRequest("POST", endpoint.URL+webhookPath, strings.NewReader(
+		`{"NotificationType":"PlaybackStop","ItemId":"01234567-89ab-cdef-0123-456789abcdef","ItemType":"Movie","Name":"Alien","NotificationUsername":"jellyfin-user","PlayedToCompletion":"True"}`,
+	))
+	req.Header.Set("X-Webhook-Secret", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s returned error: %v", webhookPath, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("POST %s status = %d (%s), want %d", webhookPath, resp.StatusCode, body, http.StatusNoContent)
+	}
+
+	if len(removed) != 1 || removed[0] != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("removed %v from the collection, want the finished movie", removed)
+	}
+	saved, err := state.Open(statePath, 0)
+	if err != nil {
+		t.Fatalf("state.Open() returned error: %v", err)
+	}
+	if _, watched := saved.User("someone").Watched["348"]; !watched {
+		t.Errorf("saved state = %v, want the movie recorded as watched", saved.User("someone"))
+	}
+}